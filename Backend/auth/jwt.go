@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenIssuer is the iss claim of tokens issued by the Backend.
+const TokenIssuer = "zocket-backend"
+
+// Claims are the JWT claims understood by the Backend. The subject is the user ID.
+type Claims struct {
+	Name string `json:"name,omitempty"`
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IssueToken returns an HS256-signed token for user that expires after ttl.
+func IssueToken(secret []byte, user models.User, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Name: user.Name,
+		Role: user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    TokenIssuer,
+			Subject:   strconv.Itoa(user.UserID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+	return signed, nil
+}
+
+// ParseToken verifies the signature, expiry and issuer of a token and returns its user as of
+// when the token was issued. Callers should refresh it with LookupUser, as the user may have
+// been deleted or changed role since.
+func ParseToken(secret []byte, token string) (models.User, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return models.User{}, fmt.Errorf("%w: invalid subject %q", ErrInvalidCredentials, claims.Subject)
+	}
+	role := claims.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+	return models.User{UserID: userID, Name: claims.Name, Role: role}, nil
+}
+
+// LookupUser returns the current name and role of a user who hasn't been deleted, so that
+// tokens stop working once their user is deleted and carry role changes immediately.
+func LookupUser(ctx context.Context, q Querier, userID int) (models.User, error) {
+	user := models.User{UserID: userID}
+	err := q.QueryRowContext(ctx, "SELECT name, role FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID).
+		Scan(&user.Name, &user.Role)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return models.User{}, fmt.Errorf("failed to look up user: %v", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned by loaders when the value does not exist. The absence is cached
+// for Options.NegativeTTL, so repeated lookups of missing keys don't reach the database.
+var ErrNotFound = errors.New("not found")
+
+// notFoundValue marks a cached absence. JSON values never start with a NUL byte.
+const notFoundValue = "\x00"
+
+// Options configures a Cache.
+type Options struct {
+	// TTL is how long values are cached. It runs from when the value was loaded, however
+	// often it is read, so stale values always expire.
+	TTL time.Duration
+	// NegativeTTL is how long an ErrNotFound from the loader is cached; 0 disables it.
+	NegativeTTL time.Duration
+	// Jitter spreads every TTL randomly by up to this fraction either way, e.g. 0.1 for
+	// ±10%, so that values cached together don't all expire together.
+	Jitter float64
+	// Backoff is how long Redis is bypassed after it fails, so that requests don't each
+	// wait for it to time out while it is down; 0 retries it on every call.
+	Backoff time.Duration
+	// OnError is called with the Redis errors the cache falls back from.
+	OnError func(err error)
+
+	// L1Size, if positive, keeps up to this many values in process memory in front of Redis,
+	// evicting the least recently used ones, so that hot keys are served without a round trip.
+	L1Size int
+	// L1TTL is how long values are kept in memory. Invalidations published while an instance
+	// isn't subscribed are lost, so it bounds how stale a value can get; 0 means TTL.
+	L1TTL time.Duration
+	// Channel is the Redis pub/sub channel Delete publishes the deleted keys to, one per line,
+	// so that Listen drops them from the memory of every instance.
+	Channel string
+}
+
+// tagPrefix prefixes the keys holding the versions of tags. Services that change tagged data
+// without a Cache increment tagPrefix+tag to invalidate it.
+const tagPrefix = "tag:"
+
+// generationStripes is the number of counters keys are spread over to tell whether they were
+// invalidated while being loaded.
+const generationStripes = 256
+
+// Stats counts the lookups of a Cache since it was created.
+type Stats struct {
+	Hits uint64 `json:"hits"`
+	// L1Hits counts the hits served from process memory, included in Hits.
+	L1Hits uint64 `json:"l1_hits"`
+	Misses uint64 `json:"misses"`
+	// Errors counts failed Redis commands, each of which was fallen back from.
+	Errors uint64 `json:"errors"`
+}
+
+// Cache is a cache-aside layer over Redis. Concurrent misses for the same key are collapsed
+// into one call of the loader, and while Redis is unavailable values are loaded directly. A
+// nil *Cache caches nothing.
+type Cache struct {
+	client redis.Cmdable
+	opts   Options
+	group  singleflight.Group
+	// downUntil is the Unix nanosecond time until which Redis is bypassed.
+	downUntil atomic.Int64
+	// l1 is nil unless Options.L1Size is positive.
+	l1 *lru
+	// generations are incremented by Delete and by invalidation messages for the keys that
+	// hash to them. A load only caches its value if its key's generation is unchanged since
+	// it started, so that a value read before an invalidation isn't cached after it.
+	generations [generationStripes]atomic.Uint64
+
+	hits, l1Hits, misses, errors atomic.Uint64
+}
+
+func New(client redis.Cmdable, opts Options) *Cache {
+	c := &Cache{client: client, opts: opts}
+	if opts.L1Size > 0 {
+		c.l1 = newLRU(opts.L1Size)
+	}
+	return c
+}
+
+// GetOrLoad returns the value cached at key, or calls load and caches its result on a miss.
+// hit reports whether the value came from the cache. Loader errors are returned as they are,
+// and ErrNotFound also when the absence was cached.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) (value []byte, hit bool, err error) {
+	if c == nil {
+		value, err = load(ctx)
+		return value, false, err
+	}
+	if c.l1 != nil {
+		if cached, ok := c.l1.get(key); ok {
+			c.hits.Add(1)
+			c.l1Hits.Add(1)
+			if string(cached) == notFoundValue {
+				return nil, true, ErrNotFound
+			}
+			return cached, true, nil
+		}
+	}
+	if c.available() {
+		cached, err := c.client.Get(ctx, key).Bytes()
+		switch {
+		case err == nil && string(cached) == notFoundValue:
+			c.hits.Add(1)
+			c.addL1(key, cached, c.opts.NegativeTTL)
+			return nil, true, ErrNotFound
+		case err == nil:
+			c.hits.Add(1)
+			c.addL1(key, cached, c.opts.TTL)
+			return cached, true, nil
+		case err != redis.Nil:
+			c.failed(err)
+		}
+	}
+
+	c.misses.Add(1)
+
+	// The loader runs for every caller waiting on key, so one of them going away must not
+	// cancel it for the others
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		return c.load(context.WithoutCancel(ctx), key, load)
+	})
+	select {
+	case res := <-ch:
+		value, _ := res.Val.([]byte)
+		return value, false, res.Err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// GetOrLoadTagged is GetOrLoad for values derived from data that is shared by many keys, such
+// as query results. The value is cached under the current versions of its tags, so that
+// InvalidateTags makes every value cached under any of them unreachable at once; a load racing
+// with the invalidation can't bring back the old value either, as it caches under the old
+// versions. Unreachable values are left to expire.
+func (c *Cache) GetOrLoadTagged(ctx context.Context, key string, tags []string, load func(ctx context.Context) ([]byte, error)) (value []byte, hit bool, err error) {
+	if c == nil {
+		value, err = load(ctx)
+		return value, false, err
+	}
+	if !c.available() {
+		c.misses.Add(1)
+		value, err = load(ctx)
+		return value, false, err
+	}
+
+	tagKeys := make([]string, len(tags))
+	for i, tag := range tags {
+		tagKeys[i] = tagPrefix + tag
+	}
+	versions, err := c.client.MGet(ctx, tagKeys...).Result()
+	if err != nil {
+		c.failed(err)
+		c.misses.Add(1)
+		value, err = load(ctx)
+		return value, false, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(key)
+	for i, version := range versions {
+		// Tags that were never invalidated have no version yet
+		v, _ := version.(string)
+		if v == "" {
+			v = "0"
+		}
+		sb.WriteString("|" + tags[i] + "@" + v)
+	}
+	return c.GetOrLoad(ctx, sb.String(), load)
+}
+
+// InvalidateTags drops every value cached under any of tags by GetOrLoadTagged. Like Delete,
+// it always tries Redis.
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) error {
+	if c == nil || len(tags) == 0 {
+		return nil
+	}
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			pipe.Incr(ctx, tagPrefix+tag)
+		}
+		return nil
+	})
+	if err != nil {
+		c.failed(err)
+		return err
+	}
+	return nil
+}
+
+// Stats returns the cache's hit, miss and error counts.
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	return Stats{Hits: c.hits.Load(), L1Hits: c.l1Hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+}
+
+func (c *Cache) load(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	generation := c.generation(key)
+	start := generation.Load()
+	value, err := load(ctx)
+	if generation.Load() != start {
+		// The key was invalidated during the load, which may have read the old value
+		return value, err
+	}
+	if err == ErrNotFound && c.opts.NegativeTTL > 0 {
+		c.set(ctx, key, notFoundValue, c.opts.NegativeTTL)
+		c.addL1(key, []byte(notFoundValue), c.opts.NegativeTTL)
+	} else if err == nil {
+		c.set(ctx, key, value, c.opts.TTL)
+		c.addL1(key, value, c.opts.TTL)
+	}
+	return value, err
+}
+
+// generation returns the generation counter of key.
+func (c *Cache) generation(key string) *atomic.Uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &c.generations[h.Sum32()%generationStripes]
+}
+
+// invalidate stops loads of keys that are in flight from caching their values, and makes
+// later callers load the keys again rather than wait for those loads.
+func (c *Cache) invalidate(keys ...string) {
+	for _, key := range keys {
+		c.generation(key).Add(1)
+		c.group.Forget(key)
+	}
+}
+
+// addL1 keeps value in memory for L1TTL, or for ttl if that is shorter.
+func (c *Cache) addL1(key string, value []byte, ttl time.Duration) {
+	if c.l1 == nil {
+		return
+	}
+	if c.opts.L1TTL > 0 {
+		ttl = min(c.opts.L1TTL, ttl)
+	}
+	c.l1.add(key, value, ttl)
+}
+
+func (c *Cache) set(ctx context.Context, key string, value interface{}, ttl time.Duration) {
+	if !c.available() {
+		return
+	}
+	if err := c.client.Set(ctx, key, value, c.ttl(ttl)).Err(); err != nil {
+		c.failed(err)
+	}
+}
+
+// Delete drops keys from the cache, and publishes them to Options.Channel for the other
+// instances to drop from memory. Loads of the keys that are in flight don't cache their
+// values. Unlike reads it always tries Redis, as a stale value outliving a change is worse
+// than a slow request.
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if c == nil {
+		return nil
+	}
+	c.invalidate(keys...)
+	if c.l1 != nil {
+		c.l1.remove(keys...)
+	}
+
+	var err error
+	if c.opts.Channel == "" {
+		err = c.client.Del(ctx, keys...).Err()
+	} else {
+		_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			pipe.Publish(ctx, c.opts.Channel, strings.Join(keys, "\n"))
+			return nil
+		})
+	}
+	if err != nil {
+		c.failed(err)
+		return err
+	}
+	return nil
+}
+
+// Listen subscribes to Options.Channel and drops the keys published to it from memory until
+// ctx is cancelled. It returns right away if the cache has no L1 tier.
+func (c *Cache) Listen(ctx context.Context, client redis.UniversalClient) {
+	if c == nil || c.l1 == nil || c.opts.Channel == "" {
+		return
+	}
+	pubsub := client.Subscribe(ctx, c.opts.Channel)
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if c.opts.OnError != nil {
+				c.opts.OnError(err)
+			}
+			// Receive reconnects on the next call; don't spin while Redis is down
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// Invalidations published before a (re)subscription were missed
+			for i := range c.generations {
+				c.generations[i].Add(1)
+			}
+			c.l1.purge()
+		case *redis.Message:
+			keys := strings.Split(msg.Payload, "\n")
+			c.invalidate(keys...)
+			c.l1.remove(keys...)
+		}
+	}
+}
+
+// ttl applies the configured jitter to ttl.
+func (c *Cache) ttl(ttl time.Duration) time.Duration {
+	if c.opts.Jitter <= 0 {
+		return ttl
+	}
+	spread := (rand.Float64()*2 - 1) * c.opts.Jitter
+	return ttl + time.Duration(float64(ttl)*spread)
+}
+
+func (c *Cache) available() bool {
+	return time.Now().UnixNano() >= c.downUntil.Load()
+}
+
+func (c *Cache) failed(err error) {
+	c.errors.Add(1)
+	if c.opts.Backoff > 0 {
+		c.downUntil.Store(time.Now().Add(c.opts.Backoff).UnixNano())
+	}
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
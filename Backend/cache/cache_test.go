@@ -0,0 +1,209 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/cache"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrLoadCollapsesConcurrentMisses(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	c := cache.New(client, cache.Options{TTL: time.Minute})
+
+	const callers = 5
+	for i := 0; i < callers; i++ {
+		mock.ExpectGet("product:1").RedisNil()
+	}
+	mock.ExpectSet("product:1", []byte(`{"product_id":1}`), time.Minute).SetVal("OK")
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return []byte(`{"product_id":1}`), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, hit, err := c.GetOrLoad(context.Background(), "product:1", load)
+			assert.NoError(t, err)
+			assert.False(t, hit)
+			assert.Equal(t, `{"product_id":1}`, string(value))
+		}()
+	}
+	// Give every caller time to miss and join the load in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loads.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrLoadDoesNotCacheLoadsRacingWithDelete(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	c := cache.New(client, cache.Options{TTL: time.Minute, L1Size: 10})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	stale := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte(`{"version":1}`), nil
+	}
+
+	mock.ExpectGet("product:1").RedisNil()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, _, err := c.GetOrLoad(context.Background(), "product:1", stale)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"version":1}`, string(value))
+	}()
+
+	// The product changes while the old version is being read
+	<-started
+	mock.ExpectDel("product:1").SetVal(0)
+	assert.NoError(t, c.Delete(context.Background(), "product:1"))
+	close(release)
+	<-done
+
+	// Neither Redis nor memory hold the old version, so the next read loads the new one
+	mock.ExpectGet("product:1").RedisNil()
+	mock.ExpectSet("product:1", []byte(`{"version":2}`), time.Minute).SetVal("OK")
+	value, hit, err := c.GetOrLoad(context.Background(), "product:1", func(ctx context.Context) ([]byte, error) {
+		return []byte(`{"version":2}`), nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, hit)
+	assert.Equal(t, `{"version":2}`, string(value))
+	assert.Equal(t, uint64(0), c.Stats().Errors)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrLoadBypassesRedisWhileDown(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	var errs []error
+	c := cache.New(client, cache.Options{TTL: time.Minute, Backoff: time.Hour, OnError: func(err error) { errs = append(errs, err) }})
+
+	mock.ExpectGet("product:1").SetErr(errors.New("connection refused"))
+
+	loads := 0
+	load := func(ctx context.Context) ([]byte, error) {
+		loads++
+		return []byte(`{}`), nil
+	}
+	for i := 0; i < 2; i++ {
+		value, hit, err := c.GetOrLoad(context.Background(), "product:1", load)
+		assert.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, `{}`, string(value))
+	}
+
+	assert.Equal(t, 2, loads)
+	assert.Len(t, errs, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrLoadCachesNotFound(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	c := cache.New(client, cache.Options{TTL: time.Minute, NegativeTTL: 10 * time.Second})
+
+	mock.ExpectGet("product:2").RedisNil()
+	mock.ExpectSet("product:2", "\x00", 10*time.Second).SetVal("OK")
+	mock.ExpectGet("product:2").SetVal("\x00")
+
+	load := func(ctx context.Context) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}
+	_, hit, err := c.GetOrLoad(context.Background(), "product:2", load)
+	assert.Equal(t, cache.ErrNotFound, err)
+	assert.False(t, hit)
+
+	_, hit, err = c.GetOrLoad(context.Background(), "product:2", func(ctx context.Context) ([]byte, error) {
+		t.Fatal("the cached absence should be used")
+		return nil, nil
+	})
+	assert.Equal(t, cache.ErrNotFound, err)
+	assert.True(t, hit)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrLoadTaggedAfterInvalidation(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	c := cache.New(client, cache.Options{TTL: time.Minute})
+
+	mock.ExpectMGet("tag:a", "tag:b").SetVal([]interface{}{nil, "2"})
+	mock.ExpectGet("list|a@0|b@2").SetVal(`[1]`)
+	mock.ExpectIncr("tag:a").SetVal(1)
+	mock.ExpectMGet("tag:a", "tag:b").SetVal([]interface{}{"1", "2"})
+	mock.ExpectGet("list|a@1|b@2").RedisNil()
+	mock.ExpectSet("list|a@1|b@2", []byte(`[1,2]`), time.Minute).SetVal("OK")
+
+	value, hit, err := c.GetOrLoadTagged(context.Background(), "list", []string{"a", "b"}, func(ctx context.Context) ([]byte, error) {
+		t.Fatal("the cached value should be used")
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, hit)
+	assert.Equal(t, `[1]`, string(value))
+
+	assert.NoError(t, c.InvalidateTags(context.Background(), "a"))
+
+	value, hit, err = c.GetOrLoadTagged(context.Background(), "list", []string{"a", "b"}, func(ctx context.Context) ([]byte, error) {
+		return []byte(`[1,2]`), nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, hit)
+	assert.Equal(t, `[1,2]`, string(value))
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1}, c.Stats())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrLoadServesHotKeysFromMemory(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	c := cache.New(client, cache.Options{TTL: time.Minute, L1Size: 1, L1TTL: time.Minute, Channel: "invalidate"})
+
+	load := func(ctx context.Context) ([]byte, error) {
+		return []byte(`{"product_id":1,"version":2}`), nil
+	}
+	get := func(key string) string {
+		value, _, err := c.GetOrLoad(context.Background(), key, load)
+		assert.NoError(t, err)
+		return string(value)
+	}
+
+	mock.ExpectGet("product:1").SetVal(`{"product_id":1}`)
+	assert.Equal(t, `{"product_id":1}`, get("product:1"))
+	assert.Equal(t, `{"product_id":1}`, get("product:1"))
+	assert.Equal(t, cache.Stats{Hits: 2, L1Hits: 1}, c.Stats())
+
+	// Deleting drops the value from memory and tells the other instances to do the same
+	mock.ExpectDel("product:1").SetVal(1)
+	mock.ExpectPublish("invalidate", "product:1").SetVal(0)
+	assert.NoError(t, c.Delete(context.Background(), "product:1"))
+	mock.ExpectGet("product:1").RedisNil()
+	mock.ExpectSet("product:1", []byte(`{"product_id":1,"version":2}`), time.Minute).SetVal("OK")
+	assert.Equal(t, `{"product_id":1,"version":2}`, get("product:1"))
+
+	// Only the most recently used value is kept
+	mock.ExpectGet("product:2").SetVal(`{"product_id":2}`)
+	assert.Equal(t, `{"product_id":2}`, get("product:2"))
+	mock.ExpectGet("product:1").SetVal(`{"product_id":1,"version":2}`)
+	assert.Equal(t, `{"product_id":1,"version":2}`, get("product:1"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a bounded in-memory map of values that evicts the least recently used one when full.
+// It is safe for concurrent use.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+// get returns the value stored at key, unless it has expired.
+func (l *lru) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.order.Remove(el)
+		delete(l.entries, key)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// add stores value at key for ttl.
+func (l *lru) add(key string, value []byte, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if el, ok := l.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		l.order.MoveToFront(el)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lru) remove(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := l.entries[key]; ok {
+			l.order.Remove(el)
+			delete(l.entries, key)
+		}
+	}
+}
+
+// purge removes every value.
+func (l *lru) purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.order.Init()
+	l.entries = make(map[string]*list.Element, l.size)
+}
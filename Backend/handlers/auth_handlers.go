@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"backend/auth"
+	"backend/config"
+	"backend/utils"
+)
+
+// tokenTTL is the lifetime of bearer tokens issued by IssueToken.
+const tokenTTL = time.Hour
+
+// IssueToken exchanges the caller's credentials (typically an API key) for a short-lived JWT.
+func IssueToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := auth.IssueToken(config.JWTSecret, user, tokenTTL)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+	}, http.StatusOK)
+	utils.Log(r.Context()).WithField("user_id", user.UserID).Info("Token issued")
+}
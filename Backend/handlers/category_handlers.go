@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error code raised when a unique constraint is violated
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == uniqueViolation
+}
+
+// categoryTree selects category_id for the category bound to the given placeholder and all
+// of its descendants. UNION rather than UNION ALL guarantees termination even if the tree
+// were ever corrupted into a cycle.
+const categoryTree = `WITH RECURSIVE tree AS (
+                  SELECT category_id FROM categories WHERE category_id = $%[1]d
+                  UNION
+                  SELECT c.category_id FROM categories c JOIN tree ON c.parent_id = tree.category_id
+              ) SELECT category_id FROM tree`
+
+// GetCategories lists every category ordered by ID. Clients build the tree from parent_id.
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	rows, err := config.DB.QueryContext(r.Context(), "SELECT category_id, name, parent_id FROM categories ORDER BY category_id")
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.ParentID); err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, categories, http.StatusOK)
+}
+
+// GetCategory returns the category in the {id} path segment.
+func GetCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var c models.Category
+	err = config.DB.QueryRowContext(r.Context(), "SELECT category_id, name, parent_id FROM categories WHERE category_id = $1", categoryID).
+		Scan(&c.ID, &c.Name, &c.ParentID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, c, http.StatusOK)
+}
+
+// AddCategory creates a category, optionally under an existing parent.
+func AddCategory(w http.ResponseWriter, r *http.Request) {
+	var c models.Category
+	if err := utils.DecodeJSONBody(w, r, &c); err != nil {
+		return
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if err := utils.Validate(&c); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	err := config.DB.QueryRowContext(r.Context(), "INSERT INTO categories (name, parent_id) VALUES ($1, $2) RETURNING category_id",
+		c.Name, c.ParentID).Scan(&c.ID)
+	if isForeignKeyViolation(err) {
+		utils.SendValidationError(w, r, &utils.ValidationError{Fields: []utils.FieldError{
+			{Field: "parent_id", Message: "does not exist"},
+		}})
+		return
+	} else if isUniqueViolation(err) {
+		categoryExists(w)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, c, http.StatusCreated)
+	utils.Log(r.Context()).WithField("category_id", c.ID).Info("Category added successfully")
+}
+
+// UpdateCategory renames the category in the {id} path segment or moves it to another
+// parent. A category cannot be moved under itself or one of its descendants.
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var update models.CategoryUpdate
+	if err := utils.DecodeJSONBody(w, r, &update); err != nil {
+		return
+	}
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		update.Name = &name
+	}
+	if err := utils.Validate(&update); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.Name != nil {
+		set("name", *update.Name)
+	}
+	if update.ParentID != nil {
+		if *update.ParentID == 0 {
+			set("parent_id", nil)
+		} else {
+			set("parent_id", *update.ParentID)
+		}
+	}
+	if len(sets) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if update.ParentID != nil && *update.ParentID != 0 {
+		// Moves are serialized so two concurrent moves cannot combine into a cycle
+		if _, err := tx.ExecContext(r.Context(), "LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		var cycle bool
+		query := `SELECT EXISTS (` + fmt.Sprintf(categoryTree, 1) + ` WHERE category_id = $2)`
+		if err := tx.QueryRowContext(r.Context(), query, categoryID, *update.ParentID).Scan(&cycle); err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if cycle {
+			utils.SendValidationError(w, r, &utils.ValidationError{Fields: []utils.FieldError{
+				{Field: "parent_id", Message: "cannot be the category itself or one of its descendants"},
+			}})
+			return
+		}
+	}
+
+	args = append(args, categoryID)
+	query := fmt.Sprintf("UPDATE categories SET %s WHERE category_id = $%d RETURNING category_id, name, parent_id",
+		strings.Join(sets, ", "), len(args))
+
+	var c models.Category
+	err = tx.QueryRowContext(r.Context(), query, args...).Scan(&c.ID, &c.Name, &c.ParentID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	} else if isForeignKeyViolation(err) {
+		utils.SendValidationError(w, r, &utils.ValidationError{Fields: []utils.FieldError{
+			{Field: "parent_id", Message: "does not exist"},
+		}})
+		return
+	} else if isUniqueViolation(err) {
+		categoryExists(w)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if update.ParentID != nil {
+		invalidateCategoryFilters(r)
+	}
+
+	utils.SendJSONResponse(w, c, http.StatusOK)
+	utils.Log(r.Context()).WithField("category_id", c.ID).Info("Category updated successfully")
+}
+
+// DeleteCategory deletes the category in the {id} path segment and removes it from every
+// product. Categories that still have subcategories cannot be deleted.
+func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(r.Context(), "DELETE FROM product_categories WHERE category_id = $1 RETURNING product_id", categoryID)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	var productIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		productIDs = append(productIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if len(productIDs) > 0 {
+		_, err = tx.ExecContext(r.Context(), "UPDATE products SET version = version + 1 WHERE product_id = ANY($1)", pq.Array(productIDs))
+		if err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := tx.ExecContext(r.Context(), "DELETE FROM categories WHERE category_id = $1", categoryID)
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Category still has subcategories; delete or move them first", http.StatusConflict)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Cached products list their category IDs
+	invalidateProductCache(r, productIDs...)
+
+	w.WriteHeader(http.StatusNoContent)
+	utils.Log(r.Context()).WithField("category_id", categoryID).Info("Category deleted successfully")
+}
+
+func categoryExists(w http.ResponseWriter) {
+	http.Error(w, "A category with this name already exists under the same parent", http.StatusConflict)
+}
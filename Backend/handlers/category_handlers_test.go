@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Subcategory", func(t *testing.T) {
+		mock.ExpectQuery(`INSERT INTO categories \(name, parent_id\) VALUES \(\$1, \$2\) RETURNING category_id`).
+			WithArgs("Tents", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"category_id"}).AddRow(5))
+
+		req := withAdmin(httptest.NewRequest(http.MethodPost, "/categories", strings.NewReader(`{"name": " Tents ", "parent_id": 2}`)))
+		w := httptest.NewRecorder()
+
+		handlers.AddCategory(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var category models.Category
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&category))
+		assert.Equal(t, 5, category.ID)
+		assert.Equal(t, "Tents", category.Name)
+	})
+
+	t.Run("Duplicate Name", func(t *testing.T) {
+		mock.ExpectQuery(`INSERT INTO categories`).
+			WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+
+		req := withAdmin(httptest.NewRequest(http.MethodPost, "/categories", strings.NewReader(`{"name": "Tents"}`)))
+		w := httptest.NewRecorder()
+
+		handlers.AddCategory(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestUpdateCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	t.Run("Move Under Descendant", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE categories`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT EXISTS \(WITH RECURSIVE tree AS .* WHERE category_id = \$2\)`).WithArgs(2, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		req := withAdmin(httptest.NewRequest(http.MethodPatch, "/categories/2", strings.NewReader(`{"parent_id": 5}`)))
+		req.SetPathValue("id", "2")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateCategory(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"parent_id"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Move To Top Level", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE categories SET parent_id = \$1 WHERE category_id = \$2`).WithArgs(nil, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"category_id", "name", "parent_id"}).AddRow(5, "Tents", nil))
+		mock.ExpectCommit()
+		redisExpect.ExpectIncr("tag:products:categories").SetVal(1)
+
+		req := withAdmin(httptest.NewRequest(http.MethodPatch, "/categories/5", strings.NewReader(`{"parent_id": 0}`)))
+		req.SetPathValue("id", "5")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateCategory(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"category_id": 5, "name": "Tents", "parent_id": null}`, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	t.Run("Unassigns Products", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`DELETE FROM product_categories WHERE category_id = \$1 RETURNING product_id`).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(8).AddRow(9))
+		mock.ExpectExec(`UPDATE products SET version = version \+ 1 WHERE product_id = ANY\(\$1\)`).WithArgs("{8,9}").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM categories WHERE category_id = \$1`).WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:8", "product:9").SetVal(1)
+		expectOwnerLookup(mock, "{8,9}", "{1,4}")
+		expectListInvalidation(redisExpect, 1, 4)
+
+		req := withAdmin(httptest.NewRequest(http.MethodDelete, "/categories/5", nil))
+		req.SetPathValue("id", "5")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteCategory(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+
+	t.Run("Has Subcategories", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`DELETE FROM product_categories`).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}))
+		mock.ExpectExec(`DELETE FROM categories`).WithArgs(2).
+			WillReturnError(&pq.Error{Code: "23503", Message: "violates foreign key constraint"})
+		mock.ExpectRollback()
+
+		req := withAdmin(httptest.NewRequest(http.MethodDelete, "/categories/2", nil))
+		req.SetPathValue("id", "2")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteCategory(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
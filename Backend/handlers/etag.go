@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/models"
+)
+
+// productETag is the entity tag of a product, "<version>.<stock version>". The product's JSON
+// includes the stock of the product and its variants, which changes without changing the
+// version, so the tag also carries the sum of their stock versions. Every stock movement
+// increases one of them, and adding or removing a variant changes the version, so the tag
+// changes whenever the JSON does.
+func productETag(product models.Product) string {
+	stockVersion := product.StockVersion
+	for _, v := range product.Variants {
+		stockVersion += v.StockVersion
+	}
+	return `"` + strconv.Itoa(product.Version) + "." + strconv.Itoa(stockVersion) + `"`
+}
+
+// productJSONETag reads the version and the ETag of a product out of its JSON.
+func productJSONETag(productJSON []byte) (version int, etag string, err error) {
+	var product models.Product
+	if err := json.Unmarshal(productJSON, &product); err != nil {
+		return 0, "", fmt.Errorf("failed to decode cached product: %v", err)
+	}
+	return product.Version, productETag(product), nil
+}
+
+// encodingSuffixes are appended to the ETags of compressed responses by middleware.Compress.
+// The tags still name the same product version.
+var encodingSuffixes = []string{"-br", "-gzip"}
+
+// trimEncoding strips the encoding suffix, if any, from an entity tag.
+func trimEncoding(tag string) string {
+	for _, suffix := range encodingSuffixes {
+		if trimmed, ok := strings.CutSuffix(tag, suffix+`"`); ok {
+			return trimmed + `"`
+		}
+	}
+	return tag
+}
+
+// splitETags splits the value of an If-Match or If-None-Match header into its entity tags.
+func splitETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notModified reports whether the If-None-Match header of r lists etag, meaning the client's
+// copy is current. As the header is used for caching, weak tags and the tags of compressed
+// copies match too.
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range splitETags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || trimEncoding(strings.TrimPrefix(tag, "W/")) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersions reads the If-Match header, which product updates require. It returns the
+// product versions the client's copy may be at, or nil if it sent "*" to accept any. The stock
+// part of the tags is ignored, since stock is changed through the inventory endpoints and
+// doesn't conflict with an update. ok is false if a 428 or 412 response has been written.
+func ifMatchVersions(w http.ResponseWriter, r *http.Request) (versions []int, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		http.Error(w, "If-Match header is required; send the ETag of the product you are updating", http.StatusPreconditionRequired)
+		return nil, false
+	}
+
+	for _, tag := range splitETags(header) {
+		if tag == "*" {
+			return nil, true
+		}
+		// If-Match uses strong comparison, so weak tags never match
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		tagVersion, _, _ := strings.Cut(strings.Trim(trimEncoding(tag), `"`), ".")
+		if version, err := strconv.Atoi(tagVersion); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		productModified(w)
+		return nil, false
+	}
+	return versions, true
+}
+
+// writeProductJSON writes the JSON of a product with its ETag, or 304 Not Modified if the
+// client's copy is current.
+func writeProductJSON(w http.ResponseWriter, r *http.Request, etag string, productJSON []byte) {
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(productJSON)
+}
+
+func productModified(w http.ResponseWriter) {
+	http.Error(w, "Product has changed since it was read; fetch it again and retry", http.StatusPreconditionFailed)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 
 	"encoding/json"
+	"errors"
 
 	"net/http"
 	"net/http/httptest"
@@ -11,12 +12,16 @@ import (
 	"testing"
     "github.com/lib/pq"
 
+	"backend/auth"
+	"backend/cache"
 	"backend/config"
 	"backend/handlers"
 	"backend/models"
+	"backend/queue"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/stretchr/testify/assert"
 
@@ -25,10 +30,73 @@ import (
 
 	"time"
 
-	
+	"context"
 
 )
 
+// productColumnNames lists the columns selected for products
+func productColumnNames() []string {
+	return []string{"product_id", "user_id", "product_name", "product_description", "product_images",
+		"compressed_product_images", "product_price", "currency", "deleted_at", "stock", "reserved", "version", "stock_version", "category_ids", "tags", "variants"}
+}
+
+// productRows returns mock rows with the columns selected for products
+func productRows() *sqlmock.Rows {
+	return sqlmock.NewRows(productColumnNames())
+}
+
+// userRows returns mock rows with the columns selected for users
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "name", "role", "deleted_at"})
+}
+
+// withUser returns req authenticated as the given user
+func withUser(req *http.Request, userID int) *http.Request {
+	return req.WithContext(auth.WithUser(req.Context(), models.User{UserID: userID, Name: "Test User"}))
+}
+
+// useRedis points the Redis client and the product caches at client until the test ends. The
+// caches have no TTL jitter, so that tests can expect exact TTLs.
+func useRedis(t *testing.T, client *redis.Client) {
+	config.RDB = client
+	config.ProductCache = cache.New(client, cache.Options{TTL: 10 * time.Minute, NegativeTTL: 30 * time.Second})
+	config.ProductListCache = cache.New(client, cache.Options{TTL: time.Minute})
+	t.Cleanup(func() {
+		config.RDB = nil
+		config.ProductCache = nil
+		config.ProductListCache = nil
+	})
+}
+
+// expectListInvalidation expects the product lists of the given owners to be invalidated.
+func expectListInvalidation(redisExpect redismock.ClientMock, userIDs ...int) {
+	redisExpect.ExpectIncr("tag:products:all").SetVal(1)
+	for _, id := range userIDs {
+		redisExpect.ExpectIncr("tag:products:user:" + strconv.Itoa(id)).SetVal(1)
+	}
+}
+
+// expectOwnerLookup expects the owners of changed products to be looked up.
+func expectOwnerLookup(mock sqlmock.Sqlmock, productIDs string, userIDs string) {
+	mock.ExpectQuery(`SELECT array_agg\(DISTINCT user_id\) FROM products WHERE product_id = ANY\(\$1\)`).WithArgs(productIDs).
+		WillReturnRows(sqlmock.NewRows([]string{"array_agg"}).AddRow(userIDs))
+}
+
+// mockPublisher records published image jobs instead of sending them to RabbitMQ
+type mockPublisher struct {
+	jobs []queue.ImageJob
+}
+
+func (p *mockPublisher) Publish(ctx context.Context, job queue.ImageJob) error {
+	p.jobs = append(p.jobs, job)
+	return nil
+}
+
+func (p *mockPublisher) PublishBatch(ctx context.Context, jobs []queue.ImageJob) error {
+	p.jobs = append(p.jobs, jobs...)
+	return nil
+}
+
 func TestGetProducts(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
@@ -36,11 +104,11 @@ func TestGetProducts(t *testing.T) {
 
 	config.DB = db
 
-	mockRows := sqlmock.NewRows([]string{"product_id", "user_id", "product_name", "product_description", "product_images", "compressed_product_images", "product_price"}).
-		AddRow(1, 1, "Product A", "Description A", `{"image1.jpg", "image2.jpg"}`, `{"compressed1.jpg"}`, 100.0).
-		AddRow(2, 2, "Product B", "Description B", `{"image3.jpg"}`, `{"compressed2.jpg"}`, 200.0)
+	mockRows := productRows().
+		AddRow(1, 1, "Product A", "Description A", `{"image1.jpg", "image2.jpg"}`, `{"compressed1.jpg"}`, "100.00", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`).
+		AddRow(2, 2, "Product B", "Description B", `{"image3.jpg"}`, `{"compressed2.jpg"}`, "200.00", "EUR", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`)
 
-	mock.ExpectQuery("SELECT product_id, user_id, product_name, product_description, product_images, compressed_product_images, product_price FROM products WHERE 1=1").
+	mock.ExpectQuery("SELECT product_id, user_id, product_name, product_description, product_images, compressed_product_images, product_price, currency, deleted_at, .* AS variants FROM products WHERE 1=1 AND deleted_at IS NULL").
 		WillReturnRows(mockRows)
 
 	req := httptest.NewRequest(http.MethodGet, "/products", nil)
@@ -55,6 +123,61 @@ func TestGetProducts(t *testing.T) {
 	err = json.NewDecoder(resp.Body).Decode(&products)
 	assert.NoError(t, err)
 	assert.Len(t, products, 2)
+	assert.Equal(t, models.Price(10000), products[0].ProductPrice)
+	assert.Equal(t, "EUR", products[1].Currency)
+}
+
+func TestGetProductsCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	t.Run("Miss", func(t *testing.T) {
+		redisExpect.ExpectMGet("tag:products:user:2").SetVal([]interface{}{nil})
+		redisExpect.Regexp().ExpectGet(`^products:list:[0-9a-f]{64}\|products:user:2@0$`).RedisNil()
+		mock.ExpectQuery(`FROM products WHERE 1=1 AND user_id = \$1 AND deleted_at IS NULL`).WithArgs(2).
+			WillReturnRows(productRows().AddRow(1, 2, "Lamp", "", `{}`, `{}`, "10.00", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`))
+		redisExpect.Regexp().ExpectSet(`^products:list:[0-9a-f]{64}\|products:user:2@0$`, `.+`, time.Minute).SetVal("OK")
+
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, httptest.NewRequest(http.MethodGet, "/products?user_id=2", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"product_name":"Lamp"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+
+	t.Run("Hit After Invalidation", func(t *testing.T) {
+		cached := `[{"product_id":1,"product_name":"Desk"}]` + "\n"
+		redisExpect.ExpectMGet("tag:products:all", "tag:products:categories").SetVal([]interface{}{"4", nil})
+		redisExpect.Regexp().ExpectGet(`^products:list:[0-9a-f]{64}\|products:all@4\|products:categories@0$`).SetVal(cached)
+
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, httptest.NewRequest(http.MethodGet, "/products?category=3", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, cached, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+
+	t.Run("Redis Unavailable", func(t *testing.T) {
+		redisExpect.ExpectMGet("tag:products:all").SetErr(errors.New("connection refused"))
+		mock.ExpectQuery(`FROM products WHERE 1=1 AND deleted_at IS NULL`).WillReturnRows(productRows())
+
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "null\n", w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, uint64(1), config.ProductListCache.Stats().Errors)
+	})
 }
 
 func TestAddProduct(t *testing.T) {
@@ -64,22 +187,30 @@ func TestAddProduct(t *testing.T) {
 
 	config.DB = db
 
-	redisMock, _ := redismock.NewClientMock()
-	config.RDB = redisMock
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
 
-	product := models.Product{
+	publisher := &mockPublisher{}
+	config.Publisher = publisher
+
+	product := models.NewProduct{
 		UserID:             1,
 		ProductName:        "New Product",
 		ProductDescription: "New Product Description",
-		ProductImages:      []string{"image1.jpg"},
-		ProductPrice:       150.0,
+		ProductImages:      []string{"https://example.com/image1.jpg"},
+		ProductPrice:       15000,
+		Currency:           "usd",
 	}
 
-	mock.ExpectQuery("INSERT INTO products").WithArgs(product.UserID, product.ProductName, product.ProductDescription, sqlmock.AnyArg(), sqlmock.AnyArg(), product.ProductPrice).
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO products").WithArgs(product.UserID, product.ProductName, product.ProductDescription, sqlmock.AnyArg(), sqlmock.AnyArg(), "150.00", "USD", 0).
 		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(1))
+	mock.ExpectCommit()
+	redisExpect.ExpectDel("product:1").SetVal(0)
+	expectListInvalidation(redisExpect, 1)
 
 	body, _ := json.Marshal(product)
-	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewReader(body))
+	req := withUser(httptest.NewRequest(http.MethodPost, "/products", bytes.NewReader(body)), product.UserID)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -92,6 +223,93 @@ func TestAddProduct(t *testing.T) {
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	assert.NoError(t, err)
 	assert.Equal(t, float64(1), response["product_id"])
+	assert.Equal(t, []queue.ImageJob{{ProductID: 1, ImageURL: "https://example.com/image1.jpg"}}, publisher.jobs)
+	assert.NoError(t, redisExpect.ExpectationsWereMet())
+
+	t.Run("Owner From Token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO products").WithArgs(7, product.ProductName, product.ProductDescription, sqlmock.AnyArg(), sqlmock.AnyArg(), "150.00", "USD", 0).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(2))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:2").SetVal(0)
+		expectListInvalidation(redisExpect, 7)
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/add", bytes.NewReader(body)), 7)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Result().StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Categories And Tags", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO products").
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(3))
+		mock.ExpectExec(`DELETE FROM product_categories WHERE product_id = \$1`).WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO product_categories \(product_id, category_id\) SELECT \$1, unnest\(\$2::integer\[\]\)`).
+			WithArgs(3, "{4,2}").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM product_tags WHERE product_id = \$1`).WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO product_tags \(product_id, tag\) SELECT \$1, unnest\(\$2::text\[\]\)`).
+			WithArgs(3, `{"outdoor","sale"}`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:3").SetVal(0)
+		expectListInvalidation(redisExpect, 1)
+
+		body := `{"product_name": "Tent", "product_price": 99, "category_ids": [4, 2, 4], "tags": ["Outdoor", " sale ", "outdoor"]}`
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/add", bytes.NewReader([]byte(body))), 1)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown Category", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO products").
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(4))
+		mock.ExpectExec(`DELETE FROM product_categories`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO product_categories`).
+			WillReturnError(&pq.Error{Code: "23503", Message: "violates foreign key constraint"})
+		mock.ExpectRollback()
+
+		body := `{"product_name": "Tent", "product_price": 99, "category_ids": [404]}`
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/add", bytes.NewReader([]byte(body))), 1)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"category_ids"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/products/add", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	})
+}
+
+// productRequest builds a GET /products/{id} request with the path value the mux would set.
+func productRequest(productID int, query string) *http.Request {
+	target := "/products/" + strconv.Itoa(productID)
+	if query != "" {
+		target += "?" + query
+	}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.SetPathValue("id", strconv.Itoa(productID))
+	return req
 }
 
 func TestGetProductByID(t *testing.T) {
@@ -103,11 +321,12 @@ func TestGetProductByID(t *testing.T) {
 	config.DB = db
 
 	redisMock, redisExpect := redismock.NewClientMock()
-	config.RDB = redisMock
+	useRedis(t, redisMock)
 
 	productID := 21
 	cacheKey := "product:" + strconv.Itoa(productID)
 
+	variantPrice := models.Price(8999)
 	product := models.Product{
 		ID:                      productID,
 		UserID:                  1,
@@ -115,17 +334,32 @@ func TestGetProductByID(t *testing.T) {
 		ProductDescription:      "A sample product for testing",
 		ProductImages:           []string{"image1.jpg", "image2.jpg"},
 		CompressedProductImages: []string{"compressed1.jpg", "compressed2.jpg"},
-		ProductPrice:            99.99,
+		ProductPrice:            9999,
+		Currency:                "USD",
+		Version:                 1,
+		StockVersion:            1,
+		CategoryIDs:             []int{2},
+		Tags:                    []string{"lamp"},
+		Variants: []models.Variant{{
+			ID:               3,
+			ProductID:        productID,
+			SKU:              "TP-RED",
+			Attributes:       map[string]string{"colour": "red"},
+			PriceOverride:    &variantPrice,
+			Images:           []string{"image1.jpg"},
+			CompressedImages: []string{},
+			Stock:            4,
+			StockVersion:     2,
+		}},
 	}
 	productJSON, _ := json.Marshal(product)
 
 	t.Run("Cache Hit", func(t *testing.T) {
 		// Mock Redis cache hit
 		redisExpect.ExpectGet(cacheKey).SetVal(string(productJSON))
-		redisExpect.ExpectExpire(cacheKey, 10*time.Minute).SetVal(true)
 
 		// Create a test request and response recorder
-		req := httptest.NewRequest(http.MethodGet, "/products/"+strconv.Itoa(productID), nil)
+		req := productRequest(productID, "")
 		w := httptest.NewRecorder()
 
 		// Call the handler
@@ -137,6 +371,7 @@ func TestGetProductByID(t *testing.T) {
 		// Validate HTTP response
 		resp := w.Result()
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"1.3"`, resp.Header.Get("ETag"))
 
 		var fetchedProduct models.Product
 		err := json.NewDecoder(resp.Body).Decode(&fetchedProduct)
@@ -144,21 +379,37 @@ func TestGetProductByID(t *testing.T) {
 		assert.Equal(t, product, fetchedProduct)
 	})
 
+	t.Run("Cache Hit Not Modified", func(t *testing.T) {
+		redisExpect.ExpectGet(cacheKey).SetVal(string(productJSON))
+
+		req := productRequest(productID, "")
+		req.Header.Set("If-None-Match", `"0.3", W/"1.3"`)
+		w := httptest.NewRecorder()
+
+		handlers.GetProductByID(w, req)
+
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"1.3"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Body.String())
+	})
+
 	t.Run("Cache Miss", func(t *testing.T) {
 		// Mock Redis cache miss
 		redisExpect.ExpectGet(cacheKey).RedisNil()
 
 		// Mock database query
-		sqlMock.ExpectQuery(`SELECT product_id, user_id, product_name, product_description, product_images, compressed_product_images, product_price`).
+		sqlMock.ExpectQuery(`SELECT product_id, user_id, product_name, product_description, product_images, compressed_product_images, product_price, currency`).
 			WithArgs(productID).
-			WillReturnRows(sqlmock.NewRows([]string{"product_id", "user_id", "product_name", "product_description", "product_images", "compressed_product_images", "product_price"}).
-				AddRow(product.ID, product.UserID, product.ProductName, product.ProductDescription, pq.Array(product.ProductImages), pq.Array(product.CompressedProductImages), product.ProductPrice))
+			WillReturnRows(productRows().
+				AddRow(product.ID, product.UserID, product.ProductName, product.ProductDescription, pq.Array(product.ProductImages), pq.Array(product.CompressedProductImages), "99.99", product.Currency, nil, 0, 0, 1, 1, `{2}`, `{lamp}`,
+					`[{"variant_id": 3, "product_id": 21, "sku": "TP-RED", "attributes": {"colour": "red"}, "price_override": 89.99, "images": ["image1.jpg"], "compressed_images": [], "stock": 4, "stock_version": 2}]`))
 
 		// Mock Redis SET operation to store fetched product
 		redisExpect.ExpectSet(cacheKey, productJSON, 10*time.Minute).SetVal("OK")
 
 		// Create a test request and response recorder
-		req := httptest.NewRequest(http.MethodGet, "/products/"+strconv.Itoa(productID), nil)
+		req := productRequest(productID, "")
 		w := httptest.NewRecorder()
 
 		// Call the handler
@@ -176,5 +427,483 @@ func TestGetProductByID(t *testing.T) {
 		err := json.NewDecoder(resp.Body).Decode(&fetchedProduct)
 		assert.NoError(t, err)
 		assert.Equal(t, product, fetchedProduct)
+		assert.Contains(t, w.Body.String(), `"product_price":99.99`)
+		assert.Equal(t, `"1.3"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("Cache Miss Not Modified", func(t *testing.T) {
+		redisExpect.ExpectGet(cacheKey).RedisNil()
+		sqlMock.ExpectQuery(`FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(productID).
+			WillReturnRows(productRows().
+				AddRow(product.ID, product.UserID, product.ProductName, product.ProductDescription, pq.Array(product.ProductImages), pq.Array(product.CompressedProductImages), "99.99", product.Currency, nil, 0, 0, 3, 0, `{2}`, `{lamp}`, `[]`))
+		redisExpect.Regexp().ExpectSet(cacheKey, `.*`, 10*time.Minute).SetVal("OK")
+
+		req := productRequest(productID, "")
+		req.Header.Set("If-None-Match", `"3.0-br"`)
+		w := httptest.NewRecorder()
+
+		handlers.GetProductByID(w, req)
+
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"3.0"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("Not Found Is Cached", func(t *testing.T) {
+		redisExpect.ExpectGet(cacheKey).RedisNil()
+		sqlMock.ExpectQuery(`FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(productID).
+			WillReturnRows(productRows())
+		redisExpect.ExpectSet(cacheKey, "\x00", 30*time.Second).SetVal("OK")
+		redisExpect.ExpectGet(cacheKey).SetVal("\x00")
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			handlers.GetProductByID(w, productRequest(productID, ""))
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Unversioned Entry", func(t *testing.T) {
+		// The entry can't be dropped, so the product is read from the database exactly once
+		redisExpect.ExpectGet(cacheKey).SetVal(`{"product_id": 21}`)
+		redisExpect.ExpectDel(cacheKey).SetErr(errors.New("connection refused"))
+		sqlMock.ExpectQuery(`FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(productID).
+			WillReturnRows(productRows().
+				AddRow(product.ID, product.UserID, product.ProductName, product.ProductDescription, pq.Array(product.ProductImages), pq.Array(product.CompressedProductImages), "99.99", product.Currency, nil, 0, 0, 2, 0, `{2}`, `{lamp}`, `[]`))
+
+		w := httptest.NewRecorder()
+		handlers.GetProductByID(w, productRequest(productID, ""))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"2.0"`, w.Header().Get("ETag"))
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("Redis Unavailable", func(t *testing.T) {
+		redisExpect.ExpectGet(cacheKey).SetErr(errors.New("connection refused"))
+		sqlMock.ExpectQuery(`FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(productID).
+			WillReturnRows(productRows().
+				AddRow(product.ID, product.UserID, product.ProductName, product.ProductDescription, pq.Array(product.ProductImages), pq.Array(product.CompressedProductImages), "99.99", product.Currency, nil, 0, 0, 1, 0, `{2}`, `{lamp}`, `[]`))
+		redisExpect.Regexp().ExpectSet(cacheKey, `.*`, 10*time.Minute).SetErr(errors.New("connection refused"))
+
+		w := httptest.NewRecorder()
+		handlers.GetProductByID(w, productRequest(productID, ""))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+}
+
+func TestGetProductsPriceFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	config.DB = db
+
+	t.Run("Exact Decimal Bounds", func(t *testing.T) {
+		mock.ExpectQuery(`FROM products WHERE 1=1 AND product_price <= \$1 AND currency = \$2`).
+			WithArgs("19.90", "EUR").
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}))
+
+		req := httptest.NewRequest(http.MethodGet, "/products?max_price=19.9&currency=eur", nil)
+		w := httptest.NewRecorder()
+
+		handlers.GetProducts(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Too Many Decimal Places", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products?min_price=0.001", nil)
+		w := httptest.NewRecorder()
+
+		handlers.GetProducts(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+}
+
+func TestGetProductsClassificationFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	mock.ExpectQuery(`FROM products WHERE 1=1 AND EXISTS \(SELECT 1 FROM product_categories pc WHERE pc.product_id = products.product_id ` +
+		`AND pc.category_id IN \(WITH RECURSIVE tree AS .* category_id = \$1.*\)\) ` +
+		`AND EXISTS \(SELECT 1 FROM product_tags pt WHERE pt.product_id = products.product_id AND pt.tag = \$2\) ` +
+		`AND EXISTS \(SELECT 1 FROM product_tags pt WHERE pt.product_id = products.product_id AND pt.tag = \$3\) AND deleted_at IS NULL`).
+		WithArgs(5, "outdoor", "sale").
+		WillReturnRows(productRows().AddRow(8, 1, "Tent", "", `{}`, `{}`, "99.00", "USD", nil, 0, 0, 1, 0, `{6}`, `{outdoor,sale}`, `[]`))
+
+	req := httptest.NewRequest(http.MethodGet, "/products?category=5&tag=Outdoor&tag=sale&tag=outdoor", nil)
+	w := httptest.NewRecorder()
+
+	handlers.GetProducts(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var products []models.Product
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&products))
+	assert.Len(t, products, 1)
+	assert.Equal(t, []int{6}, products[0].CategoryIDs)
+	assert.Equal(t, []string{"outdoor", "sale"}, products[0].Tags)
+}
+
+func TestUpdateProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	publisher := &mockPublisher{}
+	config.Publisher = publisher
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE products SET product_images = \$1, compressed_product_images = \$2, product_price = \$3, version = version \+ 1 WHERE product_id = \$4 AND deleted_at IS NULL AND version = ANY\(\$5\) RETURNING product_id`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "12.50", 21, "{1,2}").
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(21))
+	mock.ExpectExec(`UPDATE product_variants\s+SET images = ARRAY\(SELECT i FROM unnest\(images\) AS i WHERE i = ANY\(\$2\)\), compressed_images = '{}'`).
+		WithArgs(21, `{"https://example.com/new.jpg"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM products WHERE product_id = \$1`).WithArgs(21).
+		WillReturnRows(productRows().
+			AddRow(21, 1, "Lamp", "", `{"https://example.com/new.jpg"}`, `{}`, "12.50", "USD", nil, 0, 0, 3, 0, `{}`, `{}`, `[]`))
+	mock.ExpectCommit()
+	redisExpect.ExpectDel("product:21").SetVal(1)
+	expectListInvalidation(redisExpect, 1)
+
+	body := `{"product_images": ["https://example.com/new.jpg"], "product_price": "12.5"}`
+	req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21", bytes.NewReader([]byte(body))), 1)
+	req.SetPathValue("id", "21")
+	// Tags of compressed responses name the same version
+	req.Header.Set("If-Match", `"1.4", "2.0-gzip"`)
+	w := httptest.NewRecorder()
+
+	handlers.UpdateProduct(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3.0"`, w.Header().Get("ETag"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisExpect.ExpectationsWereMet())
+	assert.Equal(t, []queue.ImageJob{{ProductID: 21, ImageURL: "https://example.com/new.jpg"}}, publisher.jobs)
+
+	var updated models.Product
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&updated))
+	assert.Equal(t, models.Price(1250), updated.ProductPrice)
+
+	t.Run("Only Tags", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE products SET version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING product_id`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(21))
+		mock.ExpectExec(`DELETE FROM product_tags WHERE product_id = \$1`).WithArgs(21).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`FROM products WHERE product_id = \$1`).WithArgs(21).
+			WillReturnRows(productRows().AddRow(21, 1, "Lamp", "", `{}`, `{}`, "12.50", "USD", nil, 0, 0, 1, 0, `{3}`, `{}`, `[]`))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectListInvalidation(redisExpect, 1)
+
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21", bytes.NewReader([]byte(`{"tags": []}`))), 1)
+		req.SetPathValue("id", "21")
+		req.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateProduct(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var updated models.Product
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&updated))
+		assert.Equal(t, []int{3}, updated.CategoryIDs)
+		assert.Empty(t, updated.Tags)
+	})
+
+	t.Run("Missing If-Match", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21", bytes.NewReader([]byte(`{"tags": []}`))), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateProduct(w, req)
+
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Stale Version", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE products SET product_name = \$1, version = version \+ 1 WHERE product_id = \$2 AND deleted_at IS NULL AND version = ANY\(\$3\)`).
+			WithArgs("Desk lamp", 21, "{2}").
+			WillReturnRows(sqlmock.NewRows([]string{"product_id"}))
+		mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM products WHERE product_id = \$1 AND deleted_at IS NULL\)`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21", bytes.NewReader([]byte(`{"product_name": "Desk lamp"}`))), 1)
+		req.SetPathValue("id", "21")
+		req.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+
+		handlers.UpdateProduct(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Weak If-Match", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21", bytes.NewReader([]byte(`{"tags": []}`))), 1)
+		req.SetPathValue("id", "21")
+		req.Header.Set("If-Match", `W/"3"`)
+		w := httptest.NewRecorder()
+
+		handlers.UpdateProduct(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	mock.ExpectQuery(`UPDATE products SET deleted_at = now\(\), version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING user_id`).WithArgs(21).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	redisExpect.ExpectDel("product:21").SetVal(1)
+	expectListInvalidation(redisExpect, 1)
+
+	req := withUser(httptest.NewRequest(http.MethodDelete, "/products/21", nil), 1)
+	req.SetPathValue("id", "21")
+	w := httptest.NewRecorder()
+
+	handlers.DeleteProduct(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisExpect.ExpectationsWereMet())
+}
+
+func TestRestoreProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+	config.SoftDeleteRetention = 24 * time.Hour
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	t.Run("Within Retention", func(t *testing.T) {
+		mock.ExpectQuery(`UPDATE products SET deleted_at = NULL, version = version \+ 1\s+WHERE product_id = \$1 AND deleted_at > \$2`).
+			WithArgs(21, sqlmock.AnyArg()).
+			WillReturnRows(productRows().AddRow(21, 1, "Lamp", "", `{}`, `{}`, "12.50", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`))
+		// Drops a cached absence of the product
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectListInvalidation(redisExpect, 1)
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/restore", nil), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.RestoreProduct(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+
+	t.Run("Expired Or Not Deleted", func(t *testing.T) {
+		mock.ExpectQuery(`UPDATE products SET deleted_at = NULL`).
+			WithArgs(22, sqlmock.AnyArg()).
+			WillReturnRows(productRows())
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/22/restore", nil), 1)
+		req.SetPathValue("id", "22")
+		w := httptest.NewRecorder()
+
+		handlers.RestoreProduct(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestIncludeDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Requires Admin", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, withUser(httptest.NewRequest(http.MethodGet, "/products?include_deleted=true", nil), 1))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		handlers.GetProductByID(w, productRequest(21, "include_deleted=true"))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Admin Listing", func(t *testing.T) {
+		deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		mock.ExpectQuery(`FROM products WHERE 1=1$`).
+			WillReturnRows(productRows().AddRow(21, 1, "Lamp", "", `{}`, `{}`, "12.50", "USD", deletedAt, 0, 0, 1, 0, `{}`, `{}`, `[]`))
+
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, withAdmin(httptest.NewRequest(http.MethodGet, "/products?include_deleted=true", nil)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"deleted_at":"2024-01-02T03:04:05Z"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Admin Lookup Bypasses Cache", func(t *testing.T) {
+		mock.ExpectQuery(`FROM products WHERE product_id = \$1$`).WithArgs(21).
+			WillReturnRows(productRows().AddRow(21, 1, "Lamp", "", `{}`, `{}`, "12.50", "USD", time.Now(), 0, 0, 1, 0, `{}`, `{}`, `[]`))
+
+		w := httptest.NewRecorder()
+		handlers.GetProductByID(w, withAdmin(productRequest(21, "include_deleted=1")))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetProductsSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Ranked Prefix Match", func(t *testing.T) {
+		rows := sqlmock.NewRows(append(productColumnNames(), "search_rank", "name_highlight", "description_highlight")).
+			AddRow(3, 1, "Red Lamp", "A bright red lamp", `{}`, `{}`, "20.00", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`,
+				0.8, "\uE000Red\uE001 \uE000Lamp\uE001", "A bright \uE000red\uE001 \uE000lamp\uE001")
+
+		mock.ExpectQuery(`SELECT .*, ts_rank_cd\(search_vector, search_query\) AS search_rank,.*` +
+			`FROM products, to_tsquery\(\$1::regconfig, \$2\) AS search_query WHERE 1=1 AND user_id = \$3 ` +
+			`AND search_vector @@ search_query AND deleted_at IS NULL ORDER BY search_rank DESC, product_id`).
+			WithArgs("english", "red:* & lam:*", 1).
+			WillReturnRows(rows)
+
+		req := httptest.NewRequest(http.MethodGet, "/products?q=red+lam!&user_id=1", nil)
+		w := httptest.NewRecorder()
+
+		handlers.GetProducts(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var products []models.Product
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&products))
+		assert.Len(t, products, 1)
+		assert.Equal(t, &models.SearchMatch{
+			Rank:               0.8,
+			ProductName:        "<mark>Red</mark> <mark>Lamp</mark>",
+			ProductDescription: "A bright <mark>red</mark> <mark>lamp</mark>",
+		}, products[0].Search)
+	})
+
+	t.Run("Escapes Product Text", func(t *testing.T) {
+		rows := sqlmock.NewRows(append(productColumnNames(), "search_rank", "name_highlight", "description_highlight")).
+			AddRow(4, 1, "<script>lamp</script>", "", `{}`, `{}`, "20.00", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`,
+				0.5, "<script>\uE000lamp\uE001</script>", "\"Tom & Jerry's\" \uE000lamp\uE001")
+
+		mock.ExpectQuery(`SELECT .*FROM products, to_tsquery`).
+			WithArgs("english", "lamp:*").
+			WillReturnRows(rows)
+
+		req := httptest.NewRequest(http.MethodGet, "/products?q=lamp", nil)
+		w := httptest.NewRecorder()
+
+		handlers.GetProducts(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var products []models.Product
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&products))
+		assert.Len(t, products, 1)
+		assert.Equal(t, "&lt;script&gt;<mark>lamp</mark>&lt;/script&gt;", products[0].Search.ProductName)
+		assert.Equal(t, "&#34;Tom &amp; Jerry&#39;s&#34; <mark>lamp</mark>", products[0].Search.ProductDescription)
+	})
+
+	t.Run("No Words", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers.GetProducts(w, httptest.NewRequest(http.MethodGet, "/products?q=%26%7C!", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestGetProductFacets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Counts And Histogram", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`WITH filtered AS \(\s*SELECT user_id, product_price, currency FROM products WHERE 1=1 ` +
+			`AND product_price >= \$1 AND deleted_at IS NULL\s*\)\s*SELECT user_id, COUNT\(\*\) AS count FROM filtered ` +
+			`GROUP BY user_id ORDER BY count DESC, user_id LIMIT \$2`).
+			WithArgs("1.00", 50).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "count"}).AddRow(2, 3).AddRow(1, 1))
+
+		mock.ExpectQuery(`SELECT currency, COUNT\(\*\), MIN\(product_price\), MAX\(product_price\)\s+FROM filtered GROUP BY currency`).
+			WithArgs("1.00").
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "count", "min", "max"}).
+				AddRow("EUR", 1, "5.00", "5.00").
+				AddRow("USD", 3, "10.00", "20.03"))
+
+		// The histogram is computed against the same, rounded edges that are reported
+		mock.ExpectQuery(`unnest\(\$2::text\[\], \$3::numeric\[\], \$4::int\[\]\)`).
+			WithArgs("1.00", `{"EUR","USD","USD","USD","USD"}`, `{"5.00","10.00","12.50","15.01","17.52"}`, "{1,1,2,3,4}").
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "bucket", "count"}).
+				AddRow("EUR", 1, 1).
+				AddRow("USD", 1, 2).
+				AddRow("USD", 4, 1))
+		mock.ExpectRollback()
+
+		req := httptest.NewRequest(http.MethodGet, "/products/facets?min_price=1&buckets=4", nil)
+		w := httptest.NewRecorder()
+
+		handlers.GetProductFacets(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var facets models.ProductFacets
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&facets))
+		assert.Equal(t, 4, facets.Total)
+		assert.Equal(t, []models.UserFacet{{UserID: 2, Count: 3}, {UserID: 1, Count: 1}}, facets.Users)
+		assert.Len(t, facets.Prices, 2)
+		assert.Equal(t, []models.PriceBucket{{Min: 500, Max: 500, Count: 1}}, facets.Prices[0].Buckets)
+		assert.Equal(t, []models.PriceBucket{
+			{Min: 1000, Max: 1250, Count: 2},
+			{Min: 1250, Max: 1501},
+			{Min: 1501, Max: 1752},
+			{Min: 1752, Max: 2003, Count: 1},
+		}, facets.Prices[1].Buckets)
+	})
+
+	t.Run("Invalid Bucket Count", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers.GetProductFacets(w, httptest.NewRequest(http.MethodGet, "/products/facets?buckets=0", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"backend/auth"
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxImportBytes caps the size of product import uploads.
+const maxImportBytes = 20 << 20
+
+// importFormats maps upload content types to import formats.
+var importFormats = map[string]string{
+	"text/csv":             models.ImportCSV,
+	"application/x-ndjson": models.ImportNDJSON,
+	"application/jsonl":    models.ImportNDJSON,
+}
+
+const importJobColumns = `job_id, user_id, format, status, total_rows, processed_rows, imported_rows, failed_rows,
+              error, created_at, started_at, finished_at`
+
+func scanImportJob(row interface{ Scan(...interface{}) error }, job *models.ImportJob) error {
+	return row.Scan(&job.ID, &job.UserID, &job.Format, &job.Status, &job.TotalRows, &job.ProcessedRows,
+		&job.ImportedRows, &job.FailedRows, &job.Error, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+}
+
+// ImportProducts accepts a CSV or NDJSON upload of products and queues it as an import job,
+// answering 202 with the job. The format is taken from the format query parameter or the
+// Content-Type. The products belong to the caller; admins may pass user_id to import them
+// for someone else.
+func ImportProducts(w http.ResponseWriter, r *http.Request) {
+	caller, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[mediaType]
+	}
+	if format != models.ImportCSV && format != models.ImportNDJSON {
+		http.Error(w, "Upload must be text/csv or application/x-ndjson, or set format to csv or ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ownerID := caller.UserID
+	if v := r.URL.Query().Get("user_id"); v != "" && caller.IsAdmin() {
+		userID, err := strconv.Atoi(v)
+		if err != nil || userID <= 0 {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		var exists bool
+		err = config.DB.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND deleted_at IS NULL)", userID).
+			Scan(&exists)
+		if err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			utils.SendValidationError(w, r, &utils.ValidationError{Fields: []utils.FieldError{
+				{Field: "user_id", Message: "does not exist"},
+			}})
+			return
+		}
+		ownerID = userID
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("Upload must not be larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Problems with the upload as a whole are reported now; problems with rows go in the
+	// job's error report
+	total, err := jobs.CountImportRows(format, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if total == 0 {
+		http.Error(w, "Upload contains no products", http.StatusBadRequest)
+		return
+	}
+
+	var job models.ImportJob
+	err = scanImportJob(config.DB.QueryRowContext(r.Context(), `INSERT INTO import_jobs (user_id, created_by, format, payload, total_rows, request_id)
+              VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+importJobColumns,
+		ownerID, caller.UserID, format, payload, total, utils.RequestID(r.Context())), &job)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/imports/%d", job.ID))
+	utils.SendJSONResponse(w, job, http.StatusAccepted)
+	utils.Log(r.Context()).WithFields(logrus.Fields{
+		"job_id": job.ID,
+		"format": format,
+		"rows":   total,
+	}).Info("Product import queued")
+}
+
+// GetImportJob returns the import job in the {id} path segment and its progress.
+func GetImportJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid import job ID", http.StatusBadRequest)
+		return
+	}
+
+	var job models.ImportJob
+	err = scanImportJob(config.DB.QueryRowContext(r.Context(), "SELECT "+importJobColumns+" FROM import_jobs WHERE job_id = $1", jobID), &job)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Import job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, job, http.StatusOK)
+}
+
+// GetImportErrors downloads the error report of the import job in the {id} path segment as
+// CSV, with a line per rejected field in row order. It grows while the job runs.
+func GetImportErrors(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid import job ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := config.DB.QueryContext(r.Context(), "SELECT row_number, field, message FROM import_errors WHERE job_id = $1 ORDER BY error_id", jobID)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%d-errors.csv"`, jobID))
+	out := csv.NewWriter(w)
+	out.Write([]string{"row", "field", "message"})
+	for rows.Next() {
+		var e models.ImportError
+		if err := rows.Scan(&e.Row, &e.Field, &e.Message); err != nil {
+			// The status has been sent, so all that can be done is to cut the report short
+			utils.Log(r.Context()).WithError(err).Error("Failed to read import errors")
+			break
+		}
+		out.Write([]string{strconv.Itoa(e.Row), e.Field, e.Message})
+	}
+	if err := rows.Err(); err != nil {
+		utils.Log(r.Context()).WithError(err).Error("Failed to read import errors")
+	}
+	out.Flush()
+}
@@ -0,0 +1,119 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var importJobColumnNames = []string{"job_id", "user_id", "format", "status", "total_rows", "processed_rows",
+	"imported_rows", "failed_rows", "error", "created_at", "started_at", "finished_at"}
+
+func TestImportProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	upload := func(req *http.Request, contentType string) *httptest.ResponseRecorder {
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handlers.ImportProducts(w, req)
+		return w
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		body := "product_name,product_price,tags\nLamp,19.99,home|light\n\"Desk, oak\",120,\n"
+		mock.ExpectQuery(`INSERT INTO import_jobs \(user_id, created_by, format, payload, total_rows, request_id\)`).
+			WithArgs(5, 5, "csv", []byte(body), 2, "req-1").
+			WillReturnRows(sqlmock.NewRows(importJobColumnNames).
+				AddRow(3, 5, "csv", "pending", 2, 0, 0, 0, nil, time.Now(), nil, nil))
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/import", strings.NewReader(body)), 5)
+		req = req.WithContext(utils.WithRequestID(req.Context(), "req-1"))
+		w := upload(req, "text/csv; charset=utf-8")
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/imports/3", w.Header().Get("Location"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var job models.ImportJob
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		assert.Equal(t, 3, job.ID)
+		assert.Equal(t, models.ImportPending, job.Status)
+		assert.Equal(t, 2, job.TotalRows)
+	})
+
+	t.Run("NDJSON For Another User", func(t *testing.T) {
+		body := "{\"product_name\": \"Lamp\", \"product_price\": 19.99}\n\n{\"product_name\": \"Desk\"}\n"
+		mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM users WHERE user_id = \$1 AND deleted_at IS NULL\)`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(`INSERT INTO import_jobs`).
+			WithArgs(9, 99, "ndjson", []byte(body), 2, "").
+			WillReturnRows(sqlmock.NewRows(importJobColumnNames).
+				AddRow(4, 9, "ndjson", "pending", 2, 0, 0, 0, nil, time.Now(), nil, nil))
+
+		req := withAdmin(httptest.NewRequest(http.MethodPost, "/products/import?user_id=9", strings.NewReader(body)))
+		w := upload(req, "application/x-ndjson")
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unsupported Content Type", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/import", strings.NewReader(`[]`)), 5)
+		w := upload(req, "application/json")
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("Unknown CSV Column", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/import", strings.NewReader("product_name,product_price,colour\n")), 5)
+		w := upload(req, "text/csv")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `unknown CSV column "colour"`)
+	})
+
+	t.Run("No Rows", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/import?format=csv", strings.NewReader("product_name,product_price\n")), 5)
+		w := upload(req, "")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetImportErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	mock.ExpectQuery(`SELECT row_number, field, message FROM import_errors WHERE job_id = \$1 ORDER BY error_id`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"row_number", "field", "message"}).
+			AddRow(2, "product_price", "must be greater than 0").
+			AddRow(5, "", "has 2 fields, expected 3"))
+
+	req := withUser(httptest.NewRequest(http.MethodGet, "/imports/3/errors", nil), 5)
+	req.SetPathValue("id", "3")
+	w := httptest.NewRecorder()
+	handlers.GetImportErrors(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="import-3-errors.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "row,field,message\n2,product_price,must be greater than 0\n5,,\"has 2 fields, expected 3\"\n", w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
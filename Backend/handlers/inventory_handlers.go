@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"backend/auth"
+	"backend/config"
+	"backend/models"
+	"backend/policy"
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxStockAttempts bounds how often a movement is attempted when other movements keep
+// changing the same inventory between reading and writing it.
+const maxStockAttempts = 5
+
+var (
+	errStockNotFound = errors.New("inventory not found")
+	// errStockConflict means the inventory changed between reading and writing it.
+	errStockConflict = errors.New("inventory changed concurrently")
+	// errAlreadyReleased means a reservation has already been released.
+	errAlreadyReleased = errors.New("reservation already released")
+)
+
+// insufficientStockError is returned when a movement would leave less stock on hand than is
+// reserved.
+type insufficientStockError struct {
+	Available int
+}
+
+func (e *insufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock: %d available", e.Available)
+}
+
+// queryRower is satisfied by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// stockTarget identifies the row holding a stock level: a product, or one of its variants
+// when VariantID is set.
+type stockTarget struct {
+	ProductID int
+	VariantID int
+}
+
+// parseStockTarget reads the {id} and optional {variant_id} path segments, writing a 400 if
+// either is not a number.
+func parseStockTarget(w http.ResponseWriter, r *http.Request) (stockTarget, bool) {
+	var t stockTarget
+	var err error
+	if t.ProductID, err = strconv.Atoi(r.PathValue("id")); err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return t, false
+	}
+	if v := r.PathValue("variant_id"); v != "" {
+		if t.VariantID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+			return t, false
+		}
+	}
+	return t, true
+}
+
+// variantID returns the variant as a nullable column value.
+func (t stockTarget) variantID() interface{} {
+	if t.VariantID == 0 {
+		return nil
+	}
+	return t.VariantID
+}
+
+func (t stockTarget) notFound(w http.ResponseWriter) {
+	if t.VariantID == 0 {
+		http.Error(w, "Product not found", http.StatusNotFound)
+	} else {
+		http.Error(w, "Variant not found", http.StatusNotFound)
+	}
+}
+
+// readInventory returns the current stock level of t, or errStockNotFound if the product or
+// variant does not exist or the product was soft deleted.
+func readInventory(ctx context.Context, q queryRower, t stockTarget) (models.Inventory, error) {
+	inv := models.Inventory{ProductID: t.ProductID}
+	var row *sql.Row
+	if t.VariantID == 0 {
+		row = q.QueryRowContext(ctx, "SELECT stock, reserved, stock_version FROM products WHERE product_id = $1 AND deleted_at IS NULL", t.ProductID)
+	} else {
+		inv.VariantID = &t.VariantID
+		row = q.QueryRowContext(ctx, `SELECT v.stock, v.reserved, v.stock_version FROM product_variants v
+              WHERE v.product_id = $1 AND v.variant_id = $2
+              AND EXISTS (SELECT 1 FROM products p WHERE p.product_id = v.product_id AND p.deleted_at IS NULL)`, t.ProductID, t.VariantID)
+	}
+
+	err := row.Scan(&inv.Stock, &inv.Reserved, &inv.Version)
+	if err == sql.ErrNoRows {
+		return inv, errStockNotFound
+	} else if err != nil {
+		return inv, fmt.Errorf("failed to read inventory: %v", err)
+	}
+	inv.Available = inv.Stock - inv.Reserved
+	return inv, nil
+}
+
+// writeInventory stores the stock level in inv if the version is still the one it was read
+// at, returning errStockConflict otherwise. Stock has its own version, so movements leave the
+// product's version and ETag alone and don't lock the product row when moving variant stock.
+func writeInventory(ctx context.Context, tx *sql.Tx, t stockTarget, inv models.Inventory) error {
+	var result sql.Result
+	var err error
+	if t.VariantID == 0 {
+		result, err = tx.ExecContext(ctx, `UPDATE products SET stock = $1, reserved = $2, stock_version = stock_version + 1
+              WHERE product_id = $3 AND stock_version = $4`, inv.Stock, inv.Reserved, t.ProductID, inv.Version)
+	} else {
+		result, err = tx.ExecContext(ctx, `UPDATE product_variants SET stock = $1, reserved = $2, stock_version = stock_version + 1
+              WHERE product_id = $3 AND variant_id = $4 AND stock_version = $5`, inv.Stock, inv.Reserved, t.ProductID, t.VariantID, inv.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update inventory: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errStockConflict
+	}
+	return nil
+}
+
+// insertMovement records m in the stock ledger, filling in its ID and creation time.
+func insertMovement(ctx context.Context, tx *sql.Tx, m *models.StockMovement) error {
+	err := tx.QueryRowContext(ctx, `INSERT INTO stock_movements
+              (product_id, variant_id, kind, quantity, reservation_id, stock_after, reserved_after, reason, user_id)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING movement_id, created_at`,
+		m.ProductID, m.VariantID, m.Kind, m.Quantity, m.ReservationID, m.StockAfter, m.ReservedAfter, m.Reason, m.UserID).
+		Scan(&m.ID, &m.CreatedAt)
+	if isUniqueViolation(err) {
+		return errAlreadyReleased
+	} else if err != nil {
+		return fmt.Errorf("failed to record stock movement: %v", err)
+	}
+	return nil
+}
+
+// recordInitialStock records the stock a product or variant was created with.
+func recordInitialStock(r *http.Request, tx *sql.Tx, t stockTarget, stock int) error {
+	if stock == 0 {
+		return nil
+	}
+	caller, _ := auth.UserFromContext(r.Context())
+	m := models.StockMovement{
+		ProductID:  t.ProductID,
+		Kind:       models.MovementAdjust,
+		Quantity:   stock,
+		StockAfter: stock,
+		Reason:     "initial stock",
+		UserID:     &caller.UserID,
+	}
+	if t.VariantID != 0 {
+		m.VariantID = &t.VariantID
+	}
+	return insertMovement(r.Context(), tx, &m)
+}
+
+// moveStock applies a stock movement with optimistic concurrency. Each attempt reads the
+// inventory, lets apply check and change it, and writes it back only if no other movement
+// has changed it in the meantime, recording m in the ledger in the same transaction. Lost
+// races are retried, unless the caller pinned expectedVersion, in which case
+// errStockConflict is returned so they can re-read the inventory.
+func moveStock(ctx context.Context, t stockTarget, expectedVersion *int, m models.StockMovement, apply func(inv *models.Inventory) error) (models.Inventory, models.StockMovement, error) {
+	for attempt := 1; ; attempt++ {
+		inv, movement, err := tryMoveStock(ctx, t, expectedVersion, m, apply)
+		if err != errStockConflict || expectedVersion != nil || attempt == maxStockAttempts {
+			return inv, movement, err
+		}
+	}
+}
+
+func tryMoveStock(ctx context.Context, t stockTarget, expectedVersion *int, m models.StockMovement, apply func(inv *models.Inventory) error) (models.Inventory, models.StockMovement, error) {
+	tx, err := config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Inventory{}, m, err
+	}
+	defer tx.Rollback()
+
+	inv, err := readInventory(ctx, tx, t)
+	if err != nil {
+		return inv, m, err
+	}
+	if expectedVersion != nil && *expectedVersion != inv.Version {
+		return inv, m, errStockConflict
+	}
+
+	if err := apply(&inv); err != nil {
+		return inv, m, err
+	}
+	if err := writeInventory(ctx, tx, t, inv); err != nil {
+		return inv, m, err
+	}
+	inv.Version++
+	inv.Available = inv.Stock - inv.Reserved
+
+	m.ProductID = t.ProductID
+	m.VariantID = inv.VariantID
+	m.StockAfter = inv.Stock
+	m.ReservedAfter = inv.Reserved
+	if err := insertMovement(ctx, tx, &m); err != nil {
+		return inv, m, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return inv, m, err
+	}
+	return inv, m, nil
+}
+
+// writeStockError maps the errors returned by moveStock to responses.
+func writeStockError(w http.ResponseWriter, r *http.Request, t stockTarget, err error) {
+	var insufficient *insufficientStockError
+	switch {
+	case err == errStockNotFound:
+		t.notFound(w)
+	case err == errStockConflict:
+		http.Error(w, "Inventory was changed by another request; read it again and retry", http.StatusConflict)
+	case err == errAlreadyReleased:
+		http.Error(w, "Reservation has already been released", http.StatusConflict)
+	case errors.As(err, &insufficient):
+		utils.SendJSONResponse(w, map[string]interface{}{
+			"error":     "insufficient stock",
+			"available": insufficient.Available,
+		}, http.StatusConflict)
+	default:
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// movementResponse is the body returned after a stock movement.
+type movementResponse struct {
+	Inventory models.Inventory     `json:"inventory"`
+	Movement  models.StockMovement `json:"movement"`
+}
+
+// GetInventory returns the stock level of the product in the {id} path segment, or of the
+// variant in {variant_id}.
+func GetInventory(w http.ResponseWriter, r *http.Request) {
+	t, ok := parseStockTarget(w, r)
+	if !ok {
+		return
+	}
+
+	inv, err := readInventory(r.Context(), config.DB, t)
+	if err != nil {
+		writeStockError(w, r, t, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, inv, http.StatusOK)
+}
+
+// AdjustStock adds to or removes from the stock on hand. Stock cannot drop below the
+// reserved quantity.
+func AdjustStock(w http.ResponseWriter, r *http.Request) {
+	t, ok := parseStockTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var adjustment models.StockAdjustment
+	if err := utils.DecodeJSONBody(w, r, &adjustment); err != nil {
+		return
+	}
+	if err := utils.Validate(&adjustment); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	caller, _ := auth.UserFromContext(r.Context())
+	m := models.StockMovement{Kind: models.MovementAdjust, Quantity: adjustment.Quantity, Reason: adjustment.Reason, UserID: &caller.UserID}
+	inv, m, err := moveStock(r.Context(), t, adjustment.ExpectedVersion, m, func(inv *models.Inventory) error {
+		if inv.Stock+adjustment.Quantity < inv.Reserved {
+			return &insufficientStockError{Available: inv.Available}
+		}
+		inv.Stock += adjustment.Quantity
+		return nil
+	})
+	if err != nil {
+		writeStockError(w, r, t, err)
+		return
+	}
+
+	invalidateProductCache(r, t.ProductID)
+	utils.SendJSONResponse(w, movementResponse{Inventory: inv, Movement: m}, http.StatusOK)
+	logStockMovement(r, m)
+}
+
+// ReserveStock holds back available stock. The movement ID of the reservation is used to
+// release it again.
+func ReserveStock(w http.ResponseWriter, r *http.Request) {
+	t, ok := parseStockTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var reservation models.StockReservation
+	if err := utils.DecodeJSONBody(w, r, &reservation); err != nil {
+		return
+	}
+	if err := utils.Validate(&reservation); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	caller, _ := auth.UserFromContext(r.Context())
+	m := models.StockMovement{Kind: models.MovementReserve, Quantity: reservation.Quantity, Reason: reservation.Reason, UserID: &caller.UserID}
+	inv, m, err := moveStock(r.Context(), t, reservation.ExpectedVersion, m, func(inv *models.Inventory) error {
+		if inv.Available < reservation.Quantity {
+			return &insufficientStockError{Available: inv.Available}
+		}
+		inv.Reserved += reservation.Quantity
+		return nil
+	})
+	if err != nil {
+		writeStockError(w, r, t, err)
+		return
+	}
+
+	invalidateProductCache(r, t.ProductID)
+	utils.SendJSONResponse(w, movementResponse{Inventory: inv, Movement: m}, http.StatusCreated)
+	logStockMovement(r, m)
+}
+
+// ReleaseStock returns the stock held by a reservation. Reservations can be released once,
+// by the user who made them or by anyone who may adjust the product's stock.
+func ReleaseStock(w http.ResponseWriter, r *http.Request) {
+	t, ok := parseStockTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var release models.StockRelease
+	if err := utils.DecodeJSONBody(w, r, &release); err != nil {
+		return
+	}
+	if err := utils.Validate(&release); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	var reserved models.StockMovement
+	err := config.DB.QueryRowContext(r.Context(), `SELECT product_id, variant_id, quantity, user_id FROM stock_movements
+              WHERE movement_id = $1 AND kind = 'reserve'`, release.ReservationID).
+		Scan(&reserved.ProductID, &reserved.VariantID, &reserved.Quantity, &reserved.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows || reserved.ProductID != t.ProductID || t.variantID() != variantValue(reserved.VariantID) {
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+		return
+	}
+
+	caller, _ := auth.UserFromContext(r.Context())
+	if reserved.UserID == nil || *reserved.UserID != caller.UserID {
+		if err := policy.Authorize(r, caller, policy.AdjustStock); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	m := models.StockMovement{Kind: models.MovementRelease, Quantity: reserved.Quantity, ReservationID: &release.ReservationID,
+		Reason: release.Reason, UserID: &caller.UserID}
+	inv, m, err := moveStock(r.Context(), t, nil, m, func(inv *models.Inventory) error {
+		inv.Reserved -= reserved.Quantity
+		if inv.Reserved < 0 {
+			return fmt.Errorf("release of reservation %d would leave negative reserved stock", release.ReservationID)
+		}
+		return nil
+	})
+	if err != nil {
+		writeStockError(w, r, t, err)
+		return
+	}
+
+	invalidateProductCache(r, t.ProductID)
+	utils.SendJSONResponse(w, movementResponse{Inventory: inv, Movement: m}, http.StatusOK)
+	logStockMovement(r, m)
+}
+
+// variantValue converts a nullable variant ID to the form returned by stockTarget.variantID.
+func variantValue(id *int) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+// GetStockMovements lists the stock ledger of the product in the {id} path segment and its
+// variants, newest first. The variant_id parameter restricts it to one variant.
+func GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	p, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `SELECT movement_id, product_id, variant_id, kind, quantity, reservation_id, stock_after, reserved_after, reason, user_id, created_at
+              FROM stock_movements WHERE product_id = $1`
+	args := []interface{}{productID}
+	if v := r.URL.Query().Get("variant_id"); v != "" {
+		variantID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid variant_id", http.StatusBadRequest)
+			return
+		}
+		args = append(args, variantID)
+		query += fmt.Sprintf(" AND variant_id = $%d", len(args))
+	}
+	args = append(args, p.Limit, p.Offset)
+	query += fmt.Sprintf(" ORDER BY movement_id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := config.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	movements := []models.StockMovement{}
+	for rows.Next() {
+		var m models.StockMovement
+		err := rows.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.Kind, &m.Quantity, &m.ReservationID,
+			&m.StockAfter, &m.ReservedAfter, &m.Reason, &m.UserID, &m.CreatedAt)
+		if err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	setNextLink(w, r, p, len(movements))
+	utils.SendJSONResponse(w, movements, http.StatusOK)
+}
+
+func logStockMovement(r *http.Request, m models.StockMovement) {
+	utils.Log(r.Context()).WithFields(logrus.Fields{
+		"product_id":  m.ProductID,
+		"variant_id":  variantValue(m.VariantID),
+		"movement_id": m.ID,
+		"kind":        m.Kind,
+		"quantity":    m.Quantity,
+	}).Info("Stock movement recorded")
+}
@@ -0,0 +1,303 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func inventoryRow(stock, reserved, version int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"stock", "reserved", "stock_version"}).AddRow(stock, reserved, version)
+}
+
+func movementRow(id int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"movement_id", "created_at"}).AddRow(id, time.Now())
+}
+
+func TestReserveStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	reserve := func(body string) *httptest.ResponseRecorder {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/inventory/reserve", strings.NewReader(body)), 5)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+		handlers.ReserveStock(w, req)
+		return w
+	}
+
+	t.Run("Reserved", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 4, 7))
+		mock.ExpectExec(`UPDATE products SET stock = \$1, reserved = \$2, stock_version = stock_version \+ 1\s+WHERE product_id = \$3 AND stock_version = \$4`).
+			WithArgs(10, 6, 21, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WithArgs(21, nil, "reserve", 2, nil, 10, 6, "order 1001", 5).
+			WillReturnRows(movementRow(90))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		w := reserve(`{"quantity": 2, "reason": "order 1001"}`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var body struct {
+			Inventory models.Inventory     `json:"inventory"`
+			Movement  models.StockMovement `json:"movement"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, models.Inventory{ProductID: 21, Stock: 10, Reserved: 6, Available: 4, Version: 8}, body.Inventory)
+		assert.Equal(t, int64(90), body.Movement.ID)
+	})
+
+	t.Run("Retries Lost Race", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 6, 8))
+		mock.ExpectExec(`UPDATE products SET stock`).WithArgs(10, 9, 21, 8).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 7, 9))
+		mock.ExpectExec(`UPDATE products SET stock`).WithArgs(10, 10, 21, 9).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).WillReturnRows(movementRow(92))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		w := reserve(`{"quantity": 3}`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Variant Stock", func(t *testing.T) {
+		// Only the variant is written; the product's row and version are left alone
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT v.stock, v.reserved, v.stock_version FROM product_variants v`).WithArgs(21, 7).
+			WillReturnRows(inventoryRow(5, 0, 3))
+		mock.ExpectExec(`UPDATE product_variants SET stock = \$1, reserved = \$2, stock_version = stock_version \+ 1\s+WHERE product_id = \$3 AND variant_id = \$4 AND stock_version = \$5`).
+			WithArgs(5, 1, 21, 7, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WithArgs(21, 7, "reserve", 1, nil, 5, 1, "", 5).
+			WillReturnRows(movementRow(93))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants/7/inventory/reserve", strings.NewReader(`{"quantity": 1}`)), 5)
+		req.SetPathValue("id", "21")
+		req.SetPathValue("variant_id", "7")
+		w := httptest.NewRecorder()
+		handlers.ReserveStock(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Insufficient Stock", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 9, 10))
+		mock.ExpectRollback()
+
+		w := reserve(`{"quantity": 2}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"error": "insufficient stock", "available": 1}`, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Stale Expected Version", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 9, 10))
+		mock.ExpectRollback()
+
+		w := reserve(`{"quantity": 1, "expected_version": 9}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAdjustStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Below Reserved", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT v.stock, v.reserved, v.stock_version FROM product_variants v`).WithArgs(21, 7).
+			WillReturnRows(inventoryRow(5, 3, 2))
+		mock.ExpectRollback()
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants/7/inventory/adjust", strings.NewReader(`{"quantity": -3}`)), 1)
+		req.SetPathValue("id", "21")
+		req.SetPathValue("variant_id", "7")
+		w := httptest.NewRecorder()
+
+		handlers.AdjustStock(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Changes Product ETag", func(t *testing.T) {
+		redisMock, redisExpect := redismock.NewClientMock()
+		useRedis(t, redisMock)
+		getProduct := func(stock, stockVersion int, ifNoneMatch string) *httptest.ResponseRecorder {
+			redisExpect.ExpectGet("product:21").RedisNil()
+			mock.ExpectQuery(`FROM products WHERE product_id = \$1 AND deleted_at IS NULL`).WithArgs(21).
+				WillReturnRows(productRows().AddRow(21, 1, "Lamp", "", `{}`, `{}`, "12.50", "USD", nil, stock, 0, 1, stockVersion, `{}`, `{}`, `[]`))
+			redisExpect.Regexp().ExpectSet("product:21", `.*`, 10*time.Minute).SetVal("OK")
+
+			req := productRequest(21, "")
+			req.Header.Set("If-None-Match", ifNoneMatch)
+			w := httptest.NewRecorder()
+			handlers.GetProductByID(w, req)
+			return w
+		}
+
+		w := getProduct(5, 2, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(5, 0, 2))
+		mock.ExpectExec(`UPDATE products SET stock = \$1, reserved = \$2, stock_version = stock_version \+ 1`).
+			WithArgs(8, 0, 21, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).WillReturnRows(movementRow(91))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/inventory/adjust", strings.NewReader(`{"quantity": 3}`)), 1)
+		req.SetPathValue("id", "21")
+		w = httptest.NewRecorder()
+		handlers.AdjustStock(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// The version is unchanged, but the stock in the body isn't, so the old copy is stale
+		w = getProduct(8, 3, etag)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+		assert.Contains(t, w.Body.String(), `"stock":8`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+	})
+
+	t.Run("Zero Quantity", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/inventory/adjust", strings.NewReader(`{"quantity": 0}`)), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.AdjustStock(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"message":"must not be 0"`)
+	})
+}
+
+func TestReleaseStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	release := func(userID int) *httptest.ResponseRecorder {
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/inventory/release", strings.NewReader(`{"reservation_id": 90}`)), userID)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+		handlers.ReleaseStock(w, req)
+		return w
+	}
+	expectReservation := func() {
+		mock.ExpectQuery(`SELECT product_id, variant_id, quantity, user_id FROM stock_movements\s+WHERE movement_id = \$1 AND kind = 'reserve'`).
+			WithArgs(90).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "variant_id", "quantity", "user_id"}).AddRow(21, nil, 2, 5))
+	}
+
+	t.Run("By Reserving User", func(t *testing.T) {
+		expectReservation()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 6, 8))
+		mock.ExpectExec(`UPDATE products SET stock`).WithArgs(10, 4, 21, 8).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WithArgs(21, nil, "release", 2, 90, 10, 4, "", 5).
+			WillReturnRows(movementRow(95))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		w := release(5)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Already Released", func(t *testing.T) {
+		expectReservation()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT stock, reserved, stock_version FROM products`).WithArgs(21).
+			WillReturnRows(inventoryRow(10, 4, 9))
+		mock.ExpectExec(`UPDATE products SET stock`).WithArgs(10, 2, 21, 9).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+		mock.ExpectRollback()
+
+		w := release(5)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("By Another User", func(t *testing.T) {
+		expectReservation()
+		mock.ExpectQuery(`SELECT user_id FROM products WHERE product_id = \$1`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+
+		w := release(6)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// page is a limit/offset window parsed from the "limit" and "offset" query parameters.
+type page struct {
+	Limit  int
+	Offset int
+}
+
+func parsePage(q url.Values) (page, error) {
+	p := page{Limit: defaultPageLimit}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("offset must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+	return p, nil
+}
+
+// setNextLink adds a Link header pointing at the following page when the current page is
+// full. Clients page through results by following rel="next" until it is absent.
+func setNextLink(w http.ResponseWriter, r *http.Request, p page, count int) {
+	if count < p.Limit {
+		return
+	}
+
+	next := *r.URL
+	q := next.Query()
+	q.Set("limit", strconv.Itoa(p.Limit))
+	q.Set("offset", strconv.Itoa(p.Offset+p.Limit))
+	next.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
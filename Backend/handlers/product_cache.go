@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backend/cache"
+	"backend/config"
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// Cache tags of product lists. Lists filtered by owner depend only on that owner's products,
+// other lists on every product, and lists filtered by category also on the category tree.
+const (
+	allProductsTag   = "products:all"
+	categoriesTag    = "products:categories"
+	userProductsTag  = "products:user:"
+	productListCache = "products:list:"
+)
+
+// cacheKey returns the key of the product list matching f. Filters that always match the same
+// products share a key.
+func (f productFilter) cacheKey() string {
+	f.ProductName = strings.ToLower(f.ProductName)
+	f.Tags = append([]string(nil), f.Tags...)
+	sort.Strings(f.Tags)
+	data, _ := json.Marshal(f)
+	sum := sha256.Sum256(data)
+	return productListCache + hex.EncodeToString(sum[:])
+}
+
+// cacheTags returns the tags InvalidateProductLists drops the list matching f by.
+func (f productFilter) cacheTags() []string {
+	tags := []string{allProductsTag}
+	if f.UserID != 0 {
+		tags = []string{userProductsTag + strconv.Itoa(f.UserID)}
+	}
+	if f.CategoryID != 0 {
+		tags = append(tags, categoriesTag)
+	}
+	return tags
+}
+
+// GetCacheStats returns the hit, miss and error counts of the product caches since startup.
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	utils.SendJSONResponse(w, map[string]cache.Stats{
+		"products":      config.ProductCache.Stats(),
+		"product_lists": config.ProductListCache.Stats(),
+	}, http.StatusOK)
+}
+
+// InvalidateProductLists drops the cached product lists that may contain products of the
+// given owners, after products were added or changed outside of a handler.
+func InvalidateProductLists(ctx context.Context, userIDs ...int) {
+	tags := []string{allProductsTag}
+	for _, id := range userIDs {
+		tags = append(tags, userProductsTag+strconv.Itoa(id))
+	}
+	if err := config.ProductListCache.InvalidateTags(ctx, tags...); err != nil {
+		utils.Log(ctx).WithError(err).WithField("user_ids", userIDs).Error("Failed to invalidate product list cache")
+	}
+}
+
+// InvalidateImportedProducts drops the cached copies of products added for userID outside of a
+// handler, which may hold a cached absence from lookups made before they existed, and the
+// cached lists that may contain them.
+func InvalidateImportedProducts(ctx context.Context, userID int, productIDs []int) {
+	keys := make([]string, len(productIDs))
+	for i, id := range productIDs {
+		keys[i] = "product:" + strconv.Itoa(id)
+	}
+	if err := config.ProductCache.Delete(ctx, keys...); err != nil {
+		utils.Log(ctx).WithError(err).WithField("product_ids", productIDs).Error("Failed to invalidate product cache")
+	}
+	InvalidateProductLists(ctx, userID)
+}
+
+// invalidateCategoryFilters drops the cached product lists filtered by category, after the
+// category tree changed.
+func invalidateCategoryFilters(r *http.Request) {
+	if err := config.ProductListCache.InvalidateTags(r.Context(), categoriesTag); err != nil {
+		utils.Log(r.Context()).WithError(err).Error("Failed to invalidate product list cache")
+	}
+}
+
+// invalidateProduct drops the cached copy of a product owned by ownerID and the cached lists
+// that may contain it.
+func invalidateProduct(r *http.Request, productID, ownerID int) {
+	cacheKey := "product:" + strconv.Itoa(productID)
+	if err := config.ProductCache.Delete(r.Context(), cacheKey); err != nil {
+		utils.Log(r.Context()).WithError(err).WithField("product_id", productID).Error("Failed to invalidate product cache")
+	}
+	InvalidateProductLists(r.Context(), ownerID)
+}
+
+// invalidateProductCache is invalidateProduct for products whose owners the caller doesn't
+// have at hand; they are looked up.
+func invalidateProductCache(r *http.Request, productIDs ...int) {
+	if len(productIDs) == 0 {
+		return
+	}
+	keys := make([]string, len(productIDs))
+	for i, id := range productIDs {
+		keys[i] = "product:" + strconv.Itoa(id)
+	}
+	if err := config.ProductCache.Delete(r.Context(), keys...); err != nil {
+		utils.Log(r.Context()).WithError(err).WithField("product_ids", productIDs).Error("Failed to invalidate product cache")
+	}
+
+	var owners []int64
+	err := config.DB.QueryRowContext(r.Context(), "SELECT array_agg(DISTINCT user_id) FROM products WHERE product_id = ANY($1)", pq.Array(productIDs)).
+		Scan(pq.Array(&owners))
+	if err != nil {
+		// Lists not filtered by owner can still be dropped; the others expire by themselves
+		utils.Log(r.Context()).WithError(err).WithField("product_ids", productIDs).Error("Failed to look up owners of changed products")
+	}
+	userIDs := make([]int, len(owners))
+	for i, id := range owners {
+		userIDs[i] = int(id)
+	}
+	InvalidateProductLists(r.Context(), userIDs...)
+}
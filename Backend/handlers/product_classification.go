@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// errUnknownCategory is returned when a product is assigned a category that does not exist.
+var errUnknownCategory = errors.New("unknown category")
+
+// unknownCategoryError is the validation error reported for errUnknownCategory.
+var unknownCategoryError = &utils.ValidationError{Fields: []utils.FieldError{
+	{Field: "category_ids", Message: "contains a category that does not exist"},
+}}
+
+// replaceProductCategories makes categoryIDs the only categories of the product. It returns
+// errUnknownCategory if any of them does not exist.
+func replaceProductCategories(ctx context.Context, tx *sql.Tx, productID int, categoryIDs []int) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_categories WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to clear product categories: %v", err)
+	}
+	if len(categoryIDs) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO product_categories (product_id, category_id) SELECT $1, unnest($2::integer[])",
+		productID, pq.Array(categoryIDs))
+	if isForeignKeyViolation(err) {
+		return errUnknownCategory
+	} else if err != nil {
+		return fmt.Errorf("failed to assign product categories: %v", err)
+	}
+	return nil
+}
+
+// replaceProductTags makes tags the only tags of the product.
+func replaceProductTags(ctx context.Context, tx *sql.Tx, productID int, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_tags WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to clear product tags: %v", err)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO product_tags (product_id, tag) SELECT $1, unnest($2::text[])",
+		productID, pq.Array(tags))
+	if err != nil {
+		return fmt.Errorf("failed to assign product tags: %v", err)
+	}
+	return nil
+}
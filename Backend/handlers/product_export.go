@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exportBatchSize is how many products are fetched from the export cursor at a time, which
+// bounds the memory an export uses however many products it contains.
+const exportBatchSize = 500
+
+// exportCSVColumns are the columns of CSV exports. Lists are separated by "|", as in imports.
+var exportCSVColumns = []string{"product_id", "user_id", "product_name", "product_description", "product_images",
+	"compressed_product_images", "product_price", "currency", "stock", "reserved", "category_ids", "tags", "deleted_at"}
+
+// productWriter writes the products of an export in one format.
+type productWriter interface {
+	write(p models.Product) error
+	// close ends the export after the last product.
+	close() error
+}
+
+// newProductWriter returns a writer for format along with its content type.
+func newProductWriter(format string, w io.Writer) (productWriter, string, bool) {
+	switch format {
+	case "csv":
+		return &csvProductWriter{w: csv.NewWriter(w)}, "text/csv", true
+	case "ndjson":
+		return &ndjsonProductWriter{enc: json.NewEncoder(w)}, "application/x-ndjson", true
+	case "json":
+		return &jsonProductWriter{w: w}, "application/json", true
+	default:
+		return nil, "", false
+	}
+}
+
+type csvProductWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvProductWriter) write(p models.Product) error {
+	if !c.wroteHeader {
+		c.w.Write(exportCSVColumns)
+		c.wroteHeader = true
+	}
+
+	categoryIDs := make([]string, len(p.CategoryIDs))
+	for i, id := range p.CategoryIDs {
+		categoryIDs[i] = strconv.Itoa(id)
+	}
+	deletedAt := ""
+	if p.DeletedAt != nil {
+		deletedAt = p.DeletedAt.UTC().Format(time.RFC3339)
+	}
+
+	c.w.Write([]string{
+		strconv.Itoa(p.ID),
+		strconv.Itoa(p.UserID),
+		p.ProductName,
+		p.ProductDescription,
+		strings.Join(p.ProductImages, "|"),
+		strings.Join(p.CompressedProductImages, "|"),
+		p.ProductPrice.String(),
+		p.Currency,
+		strconv.Itoa(p.Stock),
+		strconv.Itoa(p.Reserved),
+		strings.Join(categoryIDs, "|"),
+		strings.Join(p.Tags, "|"),
+		deletedAt,
+	})
+	// csv.Writer buffers internally; hand each row to the response so batches can be flushed
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvProductWriter) close() error {
+	if !c.wroteHeader {
+		c.w.Write(exportCSVColumns)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonProductWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonProductWriter) write(p models.Product) error {
+	return n.enc.Encode(p)
+}
+
+func (n *ndjsonProductWriter) close() error {
+	return nil
+}
+
+// jsonProductWriter streams a JSON array, so it can be read like GET /products.
+type jsonProductWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonProductWriter) write(p models.Product) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if j.count == 0 {
+		sep = "["
+	}
+	j.count++
+	_, err = io.WriteString(j.w, sep+string(data)+"\n")
+	return err
+}
+
+func (j *jsonProductWriter) close() error {
+	end := "]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// ExportProducts streams every product matching the product query parameters as CSV, NDJSON
+// or a JSON array, chosen by the format parameter (default csv). Products are read through a
+// database cursor and flushed to the client batch by batch rather than collected in memory.
+func ExportProducts(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	filter, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.IncludeDeleted && !canViewDeleted(w, r) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	out, contentType, ok := newProductWriter(format, w)
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid format %q; use csv, ndjson or json", format), http.StatusBadRequest)
+		return
+	}
+
+	// Cursors only live as long as the transaction that declared them
+	tx, err := config.DB.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	from, searchColumns, args := filter.from(nil)
+	conditions, args := filter.where(args)
+	orderBy := filter.orderBy()
+	if orderBy == "" {
+		orderBy = " ORDER BY product_id"
+	}
+	query := `DECLARE product_export NO SCROLL CURSOR FOR SELECT ` + productColumns + searchColumns + `
+              FROM ` + from + ` WHERE 1=1` + conditions + orderBy
+	if _, err := tx.ExecContext(r.Context(), query, args...); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="products.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	// Once the status has been sent, errors can only be logged and the export cut short
+	count, err := exportRows(r, tx, filter, out, http.NewResponseController(w))
+	if err == nil {
+		err = out.close()
+	}
+	if err != nil {
+		utils.Log(r.Context()).WithFields(logrus.Fields{
+			"method":   r.Method,
+			"endpoint": r.URL.Path,
+			"exported": count,
+		}).WithError(err).Error("Product export failed")
+		return
+	}
+	tx.Commit()
+
+	utils.Log(r.Context()).WithFields(logrus.Fields{
+		"method":        r.Method,
+		"endpoint":      r.URL.Path,
+		"format":        format,
+		"exported":      count,
+		"response_time": time.Since(startTime),
+	}).Info("Products exported successfully")
+}
+
+// exportRows fetches the product_export cursor batch by batch, writing each product to out
+// and flushing after every batch. It returns how many products were written.
+func exportRows(r *http.Request, tx *sql.Tx, filter productFilter, out productWriter, rc *http.ResponseController) (int, error) {
+	count := 0
+	for {
+		rows, err := tx.QueryContext(r.Context(), "FETCH "+strconv.Itoa(exportBatchSize)+" FROM product_export")
+		if err != nil {
+			return count, err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			var product models.Product
+			if filter.Search != "" {
+				match := &models.SearchMatch{}
+				product, err = scanProduct(rows, &match.Rank, &match.ProductName, &match.ProductDescription)
+				highlight(match)
+				product.Search = match
+			} else {
+				product, err = scanProduct(rows)
+			}
+			if err == nil {
+				err = out.write(product)
+			}
+			if err != nil {
+				rows.Close()
+				return count, err
+			}
+			fetched++
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+
+		if fetched < exportBatchSize {
+			return count, nil
+		}
+		// Not every ResponseWriter can flush; the data is still sent when the handler returns
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return count, err
+		}
+	}
+}
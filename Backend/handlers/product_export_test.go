@@ -0,0 +1,81 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	export := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		handlers.ExportProducts(w, req)
+		return w
+	}
+
+	expectExport := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DECLARE product_export NO SCROLL CURSOR FOR SELECT product_id, .* FROM products WHERE 1=1 AND user_id = \$1 AND deleted_at IS NULL ORDER BY product_id`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`FETCH 500 FROM product_export`).
+			WillReturnRows(productRows().
+				AddRow(1, 2, "Lamp", "Warm, dimmable", `{"https://example.com/a.jpg","https://example.com/b.jpg"}`, `{}`, "19.99", "USD", nil, 5, 1, 1, 0, `{3,4}`, `{home,light}`, `[]`).
+				AddRow(2, 2, "Desk", "", `{}`, `{}`, "120.00", "EUR", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`))
+		mock.ExpectCommit()
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		expectExport()
+
+		w := export("/products/export?user_id=2")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="products.csv"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "product_id,user_id,product_name,product_description,product_images,compressed_product_images,product_price,currency,stock,reserved,category_ids,tags,deleted_at\n"+
+			"1,2,Lamp,\"Warm, dimmable\",https://example.com/a.jpg|https://example.com/b.jpg,,19.99,USD,5,1,3|4,home|light,\n"+
+			"2,2,Desk,,,,120.00,EUR,0,0,,,\n", w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		expectExport()
+
+		w := export("/products/export?user_id=2&format=ndjson")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		assert.Regexp(t, `^\{"product_id":1,.*\}\n\{"product_id":2,.*\}\n$`, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		expectExport()
+
+		w := export("/products/export?user_id=2&format=json")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Regexp(t, `^\[\{"product_id":1,.*\}\n,\{"product_id":2,.*\}\n\]\n$`, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid Format", func(t *testing.T) {
+		w := export("/products/export?format=xml")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
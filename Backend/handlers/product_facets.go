@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultPriceBuckets = 10
+	maxPriceBuckets     = 50
+	// maxUserFacets caps the per-user counts to the users with the most matching products.
+	maxUserFacets = 50
+)
+
+// GetProductFacets returns aggregates over the products matching the same filters as
+// GetProducts: the total, counts per user and, per currency, the price range and a histogram
+// with the number of buckets given by the "buckets" parameter.
+func GetProductFacets(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.IncludeDeleted && !canViewDeleted(w, r) {
+		return
+	}
+
+	buckets := defaultPriceBuckets
+	if v := r.URL.Query().Get("buckets"); v != "" {
+		buckets, err = strconv.Atoi(v)
+		if err != nil || buckets < 1 || buckets > maxPriceBuckets {
+			http.Error(w, fmt.Sprintf("buckets must be between 1 and %d", maxPriceBuckets), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The aggregates are read in separate queries, so they share a snapshot to add up
+	tx, err := config.DB.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	facets := models.ProductFacets{Users: []models.UserFacet{}, Prices: []models.PriceFacets{}}
+
+	if facets.Users, err = userFacets(r.Context(), tx, filter); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if facets.Prices, err = priceFacets(r.Context(), tx, filter, buckets); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	for _, p := range facets.Prices {
+		facets.Total += p.Count
+	}
+
+	utils.SendJSONResponse(w, facets, http.StatusOK)
+}
+
+// filteredProducts returns a CTE named "filtered" selecting the matching products.
+func filteredProducts(filter productFilter) (string, []interface{}) {
+	from, _, args := filter.from(nil)
+	conditions, args := filter.where(args)
+	return `WITH filtered AS (
+                  SELECT user_id, product_price, currency FROM ` + from + ` WHERE 1=1` + conditions + `
+              )`, args
+}
+
+func userFacets(ctx context.Context, tx *sql.Tx, filter productFilter) ([]models.UserFacet, error) {
+	cte, args := filteredProducts(filter)
+	args = append(args, maxUserFacets)
+	query := cte + fmt.Sprintf(`
+              SELECT user_id, COUNT(*) AS count FROM filtered
+              GROUP BY user_id ORDER BY count DESC, user_id LIMIT $%d`, len(args))
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count products per user: %v", err)
+	}
+	defer rows.Close()
+
+	users := []models.UserFacet{}
+	for rows.Next() {
+		var f models.UserFacet
+		if err := rows.Scan(&f.UserID, &f.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan user facet: %v", err)
+		}
+		users = append(users, f)
+	}
+	return users, rows.Err()
+}
+
+// priceFacets computes the price range and histogram per currency. The bucket edges are
+// computed once from each currency's range by priceBuckets and passed to the histogram query,
+// so products are counted against exactly the bounds that are reported: a product belongs to
+// the last bucket whose Min it reaches.
+func priceFacets(ctx context.Context, tx *sql.Tx, filter productFilter, buckets int) ([]models.PriceFacets, error) {
+	cte, args := filteredProducts(filter)
+	query := cte + `
+              SELECT currency, COUNT(*), MIN(product_price), MAX(product_price)
+              FROM filtered GROUP BY currency ORDER BY currency`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute price ranges: %v", err)
+	}
+	defer rows.Close()
+
+	prices := []models.PriceFacets{}
+	for rows.Next() {
+		var f models.PriceFacets
+		if err := rows.Scan(&f.Currency, &f.Count, &f.MinPrice, &f.MaxPrice); err != nil {
+			return nil, fmt.Errorf("failed to scan price range: %v", err)
+		}
+		f.Buckets = priceBuckets(f.MinPrice, f.MaxPrice, buckets)
+		prices = append(prices, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(prices) == 0 {
+		return prices, nil
+	}
+
+	var currencies []string
+	var bounds []models.Price
+	var numbers []int
+	for _, f := range prices {
+		for i, b := range f.Buckets {
+			currencies = append(currencies, f.Currency)
+			bounds = append(bounds, b.Min)
+			numbers = append(numbers, i+1)
+		}
+	}
+
+	cte, args = filteredProducts(filter)
+	args = append(args, pq.Array(currencies), pq.Array(bounds), pq.Array(numbers))
+	n := len(args)
+	query = cte + fmt.Sprintf(`, edges AS (
+                  SELECT * FROM unnest($%d::text[], $%d::numeric[], $%d::int[]) AS e(currency, min_price, bucket)
+              )
+              SELECT f.currency, b.bucket, COUNT(*)
+              FROM filtered f CROSS JOIN LATERAL (
+                  SELECT MAX(e.bucket) AS bucket FROM edges e
+                  WHERE e.currency = f.currency AND e.min_price <= f.product_price
+              ) b
+              WHERE b.bucket IS NOT NULL
+              GROUP BY 1, 2 ORDER BY 1, 2`, n-2, n-1, n)
+
+	rows, err = tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute price facets: %v", err)
+	}
+	defer rows.Close()
+
+	current := 0
+	for rows.Next() {
+		var currency string
+		var bucket, count int
+		if err := rows.Scan(&currency, &bucket, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan price facet: %v", err)
+		}
+
+		for current < len(prices) && prices[current].Currency != currency {
+			current++
+		}
+		if current < len(prices) && bucket >= 1 && bucket <= len(prices[current].Buckets) {
+			prices[current].Buckets[bucket-1].Count = count
+		}
+	}
+	return prices, rows.Err()
+}
+
+// priceBuckets splits [min, max] into n equally wide, empty buckets. Bounds are rounded down
+// to whole minor units.
+func priceBuckets(min, max models.Price, n int) []models.PriceBucket {
+	if min == max {
+		n = 1
+	}
+	buckets := make([]models.PriceBucket, n)
+	span := int64(max - min)
+	for i := range buckets {
+		buckets[i].Min = min + models.Price(span*int64(i)/int64(n))
+		buckets[i].Max = min + models.Price(span*int64(i+1)/int64(n))
+	}
+	return buckets
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"backend/config"
+	"backend/models"
+)
+
+// productFilter holds the query-string filters accepted by GetProducts.
+type productFilter struct {
+	UserID      int
+	MinPrice    *models.Price
+	MaxPrice    *models.Price
+	Currency    string
+	ProductName string
+	// CategoryID matches products in the category or any of its descendants.
+	CategoryID int
+	// Tags matches products carrying every one of the tags.
+	Tags []string
+	// Search is the full-text tsquery built from the q parameter, if any.
+	Search string
+	// IncludeDeleted lists soft-deleted products too. Only admins may set it.
+	IncludeDeleted bool
+}
+
+func parseProductFilter(q url.Values) (productFilter, error) {
+	var f productFilter
+
+	if v := q.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid user_id %q", v)
+		}
+		f.UserID = id
+	}
+	if v := q.Get("min_price"); v != "" {
+		p, err := models.ParsePrice(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid min_price: %v", err)
+		}
+		f.MinPrice = &p
+	}
+	if v := q.Get("max_price"); v != "" {
+		p, err := models.ParsePrice(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid max_price: %v", err)
+		}
+		f.MaxPrice = &p
+	}
+	f.Currency = strings.ToUpper(q.Get("currency"))
+	f.ProductName = q.Get("product_name")
+	if v := q.Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid category %q", v)
+		}
+		f.CategoryID = id
+	}
+	for _, tag := range models.NormalizeTags(q["tag"]) {
+		if tag != "" {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	if v := q.Get("q"); v != "" {
+		f.Search = prefixTSQuery(v)
+		if f.Search == "" {
+			return f, fmt.Errorf("q must contain at least one word")
+		}
+	}
+	if v := q.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid include_deleted %q", v)
+		}
+		f.IncludeDeleted = include
+	}
+
+	return f, nil
+}
+
+// prefixTSQuery turns free text into a tsquery matching every word as a prefix, so "red lam"
+// matches "Red Lamp". Anything other than letters and digits is treated as a separator, which
+// keeps user input from injecting tsquery operators.
+func prefixTSQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Matched words are delimited by private-use characters rather than HTML tags so that the
+// snippet can be HTML-escaped before the delimiters are turned into <mark> tags.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+// highlightOptions delimits matched words and keeps snippets short.
+const highlightOptions = "'StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxWords=35, MinWords=15, MaxFragments=2'"
+
+// highlighter HTML-escapes a snippet and wraps its matched words in <mark> tags.
+var highlighter = strings.NewReplacer(
+	highlightStart, "<mark>",
+	highlightStop, "</mark>",
+	"<", "&lt;",
+	">", "&gt;",
+	"&", "&amp;",
+	"'", "&#39;",
+	`"`, "&#34;",
+)
+
+// highlight renders the snippets of a search match as escaped HTML.
+func highlight(match *models.SearchMatch) {
+	match.ProductName = highlighter.Replace(match.ProductName)
+	match.ProductDescription = highlighter.Replace(match.ProductDescription)
+}
+
+// from returns the FROM clause for the filter along with any extra columns to select after
+// productColumns. Full-text searches join the parsed query as search_query so the WHERE
+// clause, ranking and highlighting can all refer to it, and select search_rank,
+// name_highlight and description_highlight.
+func (f productFilter) from(args []interface{}) (from string, columns string, _ []interface{}) {
+	if f.Search == "" {
+		return "products", "", args
+	}
+
+	args = append(args, config.SearchLanguage, f.Search)
+	lang, query := len(args)-1, len(args)
+
+	from = fmt.Sprintf("products, to_tsquery($%d::regconfig, $%d) AS search_query", lang, query)
+	columns = fmt.Sprintf(`, ts_rank_cd(search_vector, search_query) AS search_rank,
+              ts_headline($%[1]d::regconfig, product_name, search_query, %[2]s) AS name_highlight,
+              ts_headline($%[1]d::regconfig, coalesce(product_description, ''), search_query, %[2]s) AS description_highlight`,
+		lang, highlightOptions)
+	return from, columns, args
+}
+
+// orderBy returns the ORDER BY clause: by relevance for searches, by ID otherwise.
+func (f productFilter) orderBy() string {
+	if f.Search != "" {
+		return " ORDER BY search_rank DESC, product_id"
+	}
+	return ""
+}
+
+// where returns the SQL conditions for the filter, each prefixed with " AND ", numbering
+// placeholders after any arguments already in args.
+func (f productFilter) where(args []interface{}) (string, []interface{}) {
+	var sb strings.Builder
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		fmt.Fprintf(&sb, " AND "+cond, len(args))
+	}
+
+	if f.UserID != 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	// Prices are bound as decimal strings so the comparison happens in NUMERIC, not float
+	if f.MinPrice != nil {
+		add("product_price >= $%d", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		add("product_price <= $%d", *f.MaxPrice)
+	}
+	if f.Currency != "" {
+		add("currency = $%d", f.Currency)
+	}
+	if f.ProductName != "" {
+		add("product_name ILIKE $%d", "%"+f.ProductName+"%")
+	}
+	if f.CategoryID != 0 {
+		args = append(args, f.CategoryID)
+		sb.WriteString(" AND EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = products.product_id AND pc.category_id IN (" +
+			fmt.Sprintf(categoryTree, len(args)) + "))")
+	}
+	for _, tag := range f.Tags {
+		add("EXISTS (SELECT 1 FROM product_tags pt WHERE pt.product_id = products.product_id AND pt.tag = $%d)", tag)
+	}
+	if f.Search != "" {
+		sb.WriteString(" AND search_vector @@ search_query")
+	}
+	if !f.IncludeDeleted {
+		sb.WriteString(" AND deleted_at IS NULL")
+	}
+	return sb.String(), args
+}
@@ -1,17 +1,54 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"backend/auth"
 	"backend/config"
 	"backend/models"
+	"backend/policy"
 	"backend/utils"
 )
 
+// userColumns lists the columns scanned into a models.User, in order.
+const userColumns = "user_id, name, role, deleted_at"
+
+// GetUsers lists users a page at a time, ordered by ID. Admins see every user; other callers
+// only see themselves.
 func GetUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.DB.Query("SELECT user_id, name FROM users")
+	caller, _ := auth.UserFromContext(r.Context())
+
+	p, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE 1=1"
+	args := []interface{}{}
+	if !caller.IsAdmin() {
+		query += " AND user_id = $1"
+		args = append(args, caller.UserID)
+	}
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	args = append(args, p.Limit, p.Offset)
+	query += fmt.Sprintf(" ORDER BY user_id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := config.DB.QueryContext(r.Context(), query, args...)
 	if err != nil {
-		utils.HandleError(w, err, http.StatusInternalServerError)
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
@@ -19,39 +56,277 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		if err := rows.Scan(&user.UserID, &user.Name); err != nil {
-			utils.HandleError(w, err, http.StatusInternalServerError)
+		if err := rows.Scan(&user.UserID, &user.Name, &user.Role, &user.DeletedAt); err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
 			return
 		}
 		users = append(users, user)
 	}
+	if err := rows.Err(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
 
+	setNextLink(w, r, p, len(users))
 	utils.SendJSONResponse(w, users, http.StatusOK)
 }
 
 func AddUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		utils.Logger.Warn("Invalid request method for addUser")
+		utils.Log(r.Context()).Warn("Invalid request method for addUser")
 		return
 	}
 
 	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	if err := utils.DecodeJSONBody(w, r, &user); err != nil {
+		return
+	}
+	if err := utils.Validate(&user); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+	// Users always sign up with the default role; admins are promoted in the database
+	user.Role = models.RoleUser
+
+	// The user and their first API key are created together so a new user is never locked out
+	tx, err := config.DB.BeginTx(r.Context(), nil)
 	if err != nil {
-		utils.Logger.WithError(err).Error("Failed to decode addUser request body")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	err = config.DB.QueryRow("INSERT INTO users (name) VALUES ($1) RETURNING user_id", user.Name).Scan(&user.UserID)
+	err = tx.QueryRowContext(r.Context(), "INSERT INTO users (name) VALUES ($1) RETURNING user_id", user.Name).Scan(&user.UserID)
 	if err != nil {
-		utils.Logger.WithError(err).Error("Failed to insert new user")
+		utils.Log(r.Context()).WithError(err).Error("Failed to insert new user")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	apiKey, err := auth.CreateAPIKey(r.Context(), tx, user.UserID, "default")
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	// The plaintext API key is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
-	utils.Logger.WithField("user_id", user.UserID).Info("User added successfully")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": user.UserID,
+		"name":    user.Name,
+		"role":    user.Role,
+		"api_key": apiKey,
+	})
+	utils.Log(r.Context()).WithField("user_id", user.UserID).Info("User added successfully")
+}
+
+// GetUser returns the user in the {id} path segment.
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	includeDeleted, ok := parseIncludeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE user_id = $1"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
+	var user models.User
+	err = config.DB.QueryRowContext(r.Context(), query, userID).
+		Scan(&user.UserID, &user.Name, &user.Role, &user.DeletedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, user, http.StatusOK)
+}
+
+// UpdateUser applies a partial update to the user in the {id} path segment. Only admins may
+// change roles.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var update models.UserUpdate
+	if err := utils.DecodeJSONBody(w, r, &update); err != nil {
+		return
+	}
+	if err := utils.Validate(&update); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	if update.Role != nil {
+		caller, _ := auth.UserFromContext(r.Context())
+		if err := policy.Authorize(r, caller, policy.ChangeUserRole); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.Name != nil {
+		set("name", *update.Name)
+	}
+	if update.Role != nil {
+		set("role", *update.Role)
+	}
+	if len(sets) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	args = append(args, userID)
+	query := fmt.Sprintf("UPDATE users SET %s WHERE user_id = $%d AND deleted_at IS NULL RETURNING %s",
+		strings.Join(sets, ", "), len(args), userColumns)
+
+	var user models.User
+	err = config.DB.QueryRowContext(r.Context(), query, args...).Scan(&user.UserID, &user.Name, &user.Role, &user.DeletedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, user, http.StatusOK)
+	utils.Log(r.Context()).WithField("user_id", user.UserID).Info("User updated successfully")
+}
+
+// DeleteUser soft deletes the user in the {id} path segment, which also disables their API
+// keys. Users who still own products cannot be deleted: their products must be deleted or
+// reassigned first, so that product images and history are never removed as a side effect.
+// The purge job removes the user and their keys once the retention period has passed.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(r.Context(), "UPDATE users SET deleted_at = now() WHERE user_id = $1 AND deleted_at IS NULL", userID)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var productCount int
+	err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM products WHERE user_id = $1 AND deleted_at IS NULL", userID).Scan(&productCount)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if productCount > 0 {
+		userHasProducts(w, productCount)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	utils.Log(r.Context()).WithField("user_id", userID).Info("User deleted successfully")
+}
+
+// RestoreUser undoes the soft delete of the user in the {id} path segment, as long as it is
+// still within the retention period.
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := "UPDATE users SET deleted_at = NULL WHERE user_id = $1 AND deleted_at > $2 RETURNING " + userColumns
+
+	var user models.User
+	err = config.DB.QueryRowContext(r.Context(), query, userID, time.Now().Add(-config.SoftDeleteRetention)).
+		Scan(&user.UserID, &user.Name, &user.Role, &user.DeletedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No restorable user found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, user, http.StatusOK)
+	utils.Log(r.Context()).WithField("user_id", userID).Info("User restored successfully")
+}
+
+func userHasProducts(w http.ResponseWriter, count int) {
+	utils.SendJSONResponse(w, map[string]interface{}{
+		"error":         "user still owns products; delete or reassign them first",
+		"product_count": count,
+	}, http.StatusConflict)
+}
+
+// GetUserProducts lists the products owned by the user in the {id} path segment. It accepts
+// the same filters as GetProducts.
+func GetUserProducts(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.UserID = userID
+
+	var exists bool
+	err = config.DB.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND deleted_at IS NULL)", userID).Scan(&exists)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	listProducts(w, r, filter)
 }
@@ -0,0 +1,181 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/auth"
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func withAdmin(req *http.Request) *http.Request {
+	return req.WithContext(auth.WithUser(req.Context(), models.User{UserID: 99, Name: "Admin", Role: models.RoleAdmin}))
+}
+
+func TestGetUsersPagination(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	mock.ExpectQuery(`SELECT user_id, name, role, deleted_at FROM users WHERE 1=1 AND deleted_at IS NULL ORDER BY user_id LIMIT \$1 OFFSET \$2`).
+		WithArgs(2, 4).
+		WillReturnRows(userRows().
+			AddRow(5, "Eve", "user", nil).
+			AddRow(6, "Frank", "admin", nil))
+
+	req := withAdmin(httptest.NewRequest(http.MethodGet, "/users?limit=2&offset=4", nil))
+	w := httptest.NewRecorder()
+
+	handlers.GetUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `</users?limit=2&offset=6>; rel="next"`, w.Header().Get("Link"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	handlers.GetUsers(w, withAdmin(httptest.NewRequest(http.MethodGet, "/users?limit=1000", nil)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectQuery(`SELECT user_id, name, role, deleted_at FROM users`).
+		WillReturnRows(userRows().
+			AddRow(5, "Eve", "user", nil).
+			RowError(0, errors.New("connection reset")))
+
+	w = httptest.NewRecorder()
+	handlers.GetUsers(w, withAdmin(httptest.NewRequest(http.MethodGet, "/users", nil)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Rename", func(t *testing.T) {
+		mock.ExpectQuery(`UPDATE users SET name = \$1 WHERE user_id = \$2 AND deleted_at IS NULL RETURNING user_id, name, role, deleted_at`).
+			WithArgs("Alice", 1).
+			WillReturnRows(userRows().AddRow(1, "Alice", "user", nil))
+
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"name": "Alice"}`)), 1)
+		req.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateUser(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Role Change Requires Admin", func(t *testing.T) {
+		req := withUser(httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"role": "admin"}`)), 1)
+		req.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateUser(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Invalid Role", func(t *testing.T) {
+		req := withAdmin(httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"role": "owner"}`)))
+		req.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+
+		handlers.UpdateUser(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	t.Run("Owns Products", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE users SET deleted_at = now\(\)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM products WHERE user_id = \$1 AND deleted_at IS NULL`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectRollback()
+
+		req := withUser(httptest.NewRequest(http.MethodDelete, "/users/1", nil), 1)
+		req.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteUser(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), `"product_count":3`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Already Deleted", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE users SET deleted_at = now\(\)`).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		req := withUser(httptest.NewRequest(http.MethodDelete, "/users/3", nil), 3)
+		req.SetPathValue("id", "3")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteUser(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No Products", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE users SET deleted_at = now\(\)`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM products WHERE user_id = \$1 AND deleted_at IS NULL`).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectCommit()
+
+		req := withUser(httptest.NewRequest(http.MethodDelete, "/users/2", nil), 2)
+		req.SetPathValue("id", "2")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteUser(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetUserProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`FROM products WHERE 1=1 AND user_id = \$1 AND product_name ILIKE \$2`).
+		WithArgs(4, "%lamp%").
+		WillReturnRows(productRows().
+			AddRow(1, 4, "Desk Lamp", "", `{}`, `{}`, "20.00", "USD", nil, 0, 0, 1, 0, `{}`, `{}`, `[]`))
+
+	// A user_id query parameter cannot widen the listing to another user
+	req := httptest.NewRequest(http.MethodGet, "/users/4/products?user_id=7&product_name=lamp", nil)
+	req.SetPathValue("id", "4")
+	w := httptest.NewRecorder()
+
+	handlers.GetUserProducts(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Desk Lamp")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,126 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddProductValidation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	config.DB = db
+	config.Publisher = &mockPublisher{}
+
+	t.Run("Invalid Fields", func(t *testing.T) {
+		body := `{"product_name": "  ", "product_images": ["not a url"], "product_price": -5}`
+		req := httptest.NewRequest(http.MethodPost, "/products/add", strings.NewReader(body))
+		req = withUser(req, 42)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		var response struct {
+			Fields []utils.FieldError `json:"fields"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		assert.ElementsMatch(t, []utils.FieldError{
+			{Field: "product_name", Message: "is required"},
+			{Field: "product_images[0]", Message: "must be a valid http or https URL"},
+			{Field: "product_price", Message: "must be greater than 0.00"},
+		}, response.Fields)
+	})
+
+	t.Run("Unknown Field", func(t *testing.T) {
+		body := `{"product_name": "Lamp", "product_price": 10, "colour": "red"}`
+		req := httptest.NewRequest(http.MethodPost, "/products/add", strings.NewReader(body))
+		req = withUser(req, 42)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	})
+
+	t.Run("Server Owned Fields", func(t *testing.T) {
+		for _, field := range []string{
+			`"product_id": 7`, `"reserved": 2`, `"version": 3`, `"variants": []`,
+			`"search": {}`, `"deleted_at": null`, `"compressed_product_images": []`,
+		} {
+			body := `{"product_name": "Lamp", "product_price": 10, ` + field + `}`
+			req := httptest.NewRequest(http.MethodPost, "/products/add", strings.NewReader(body))
+			req = withUser(req, 42)
+			w := httptest.NewRecorder()
+
+			handlers.AddProduct(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, field)
+		}
+	})
+
+	t.Run("Body Too Large", func(t *testing.T) {
+		body := `{"product_description": "` + strings.Repeat("a", utils.MaxRequestBodyBytes) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/products/add", strings.NewReader(body))
+		req = withUser(req, 42)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Result().StatusCode)
+	})
+
+	t.Run("Unknown User", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO products").
+			WillReturnError(&pq.Error{Code: "23503", Message: "violates foreign key constraint"})
+		mock.ExpectRollback()
+
+		// The authenticated user no longer exists
+		body := `{"product_name": "Lamp", "product_price": 10}`
+		req := httptest.NewRequest(http.MethodPost, "/products/add", strings.NewReader(body))
+		req = withUser(req, 42)
+		w := httptest.NewRecorder()
+
+		handlers.AddProduct(w, req)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Contains(t, w.Body.String(), `"field":"user_id"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddUserValidation(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/users/add", strings.NewReader(`{"name": ""}`))
+	w := httptest.NewRecorder()
+
+	handlers.AddUser(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+	assert.Contains(t, w.Body.String(), `"field":"name"`)
+}
+
+func TestHandleValidationError(t *testing.T) {
+	err := utils.Validate("not a struct")
+	assert.Error(t, err)
+	assert.NotErrorAs(t, err, new(*utils.ValidationError))
+
+	w := httptest.NewRecorder()
+	utils.HandleValidationError(w, httptest.NewRequest(http.MethodPost, "/products/add", nil), err)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
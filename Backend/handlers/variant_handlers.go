@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+	"backend/queue"
+	"backend/utils"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// variantColumns lists the columns read by scanVariant, in order.
+const variantColumns = "variant_id, product_id, sku, attributes, price_override, images, compressed_images, stock, reserved, stock_version"
+
+// variantsJSON aggregates a product's variants into a JSON array with the same fields as
+// models.Variant, so they can be selected alongside productColumns.
+const variantsJSON = `coalesce((SELECT json_agg(json_build_object(
+                  'variant_id', v.variant_id, 'product_id', v.product_id, 'sku', v.sku, 'attributes', v.attributes,
+                  'price_override', v.price_override, 'images', v.images, 'compressed_images', v.compressed_images,
+                  'stock', v.stock, 'reserved', v.reserved, 'stock_version', v.stock_version) ORDER BY v.variant_id)
+              FROM product_variants v WHERE v.product_id = products.product_id), '[]') AS variants`
+
+func scanVariant(row rowScanner) (models.Variant, error) {
+	var v models.Variant
+	var attributes []byte
+	err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &attributes, &v.PriceOverride,
+		pq.Array(&v.Images), pq.Array(&v.CompressedImages), &v.Stock, &v.Reserved, &v.StockVersion)
+	if err != nil {
+		return models.Variant{}, err
+	}
+	if err := json.Unmarshal(attributes, &v.Attributes); err != nil {
+		return models.Variant{}, fmt.Errorf("invalid attributes for variant %d: %v", v.ID, err)
+	}
+	return v, nil
+}
+
+// parseVariantPath reads the {id} and {variant_id} path segments, writing a 400 if either is
+// not a number.
+func parseVariantPath(w http.ResponseWriter, r *http.Request) (productID, variantID int, ok bool) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	variantID, err = strconv.Atoi(r.PathValue("variant_id"))
+	if err != nil {
+		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return productID, variantID, true
+}
+
+// GetVariants lists the variants of the product in the {id} path segment.
+func GetVariants(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = config.DB.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM products WHERE product_id = $1 AND deleted_at IS NULL)", productID).Scan(&exists)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := config.DB.QueryContext(r.Context(), "SELECT "+variantColumns+" FROM product_variants WHERE product_id = $1 ORDER BY variant_id", productID)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	variants := []models.Variant{}
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		variants = append(variants, v)
+	}
+	if err := rows.Err(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, variants, http.StatusOK)
+}
+
+// GetVariant returns the variant in the {variant_id} path segment of the product in {id}.
+func GetVariant(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, ok := parseVariantPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + variantColumns + ` FROM product_variants v
+              WHERE v.product_id = $1 AND v.variant_id = $2
+              AND EXISTS (SELECT 1 FROM products p WHERE p.product_id = v.product_id AND p.deleted_at IS NULL)`
+
+	v, err := scanVariant(config.DB.QueryRowContext(r.Context(), query, productID, variantID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Variant not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, v, http.StatusOK)
+}
+
+// AddVariant creates a variant of the product in the {id} path segment and queues its images
+// for compression.
+func AddVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var input models.NewVariant
+	if err := utils.DecodeJSONBody(w, r, &input); err != nil {
+		return
+	}
+	v := input.Variant()
+	if err := utils.Validate(&v); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+	attributes, err := json.Marshal(v.Attributes)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := config.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkVariantImages(w, r, tx, productID, v.Images) {
+		return
+	}
+
+	query := `INSERT INTO product_variants (product_id, sku, attributes, price_override, images, stock)
+              VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + variantColumns
+
+	v, err = scanVariant(tx.QueryRowContext(r.Context(), query,
+		productID, v.SKU, string(attributes), v.PriceOverride, pq.Array(v.Images), v.Stock))
+	if isUniqueViolation(err) {
+		skuExists(w)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := recordInitialStock(r, tx, stockTarget{ProductID: productID, VariantID: v.ID}, v.Stock); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	invalidateProductCache(r, productID)
+	publishVariantImageJobs(r, v)
+
+	utils.SendJSONResponse(w, v, http.StatusCreated)
+	utils.Log(r.Context()).WithFields(logrus.Fields{"product_id": productID, "variant_id": v.ID}).Info("Variant added successfully")
+}
+
+// UpdateVariant applies a partial update to a variant. Replacing the images discards the
+// previously compressed copies and queues the new images.
+func UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, ok := parseVariantPath(w, r)
+	if !ok {
+		return
+	}
+
+	var update models.VariantUpdate
+	if err := utils.DecodeJSONBody(w, r, &update); err != nil {
+		return
+	}
+	if update.SKU != nil {
+		sku := strings.TrimSpace(*update.SKU)
+		update.SKU = &sku
+	}
+	if err := utils.Validate(&update); err != nil {
+		utils.HandleValidationError(w, r, err)
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.SKU != nil {
+		set("sku", *update.SKU)
+	}
+	if update.Attributes != nil {
+		attributes, err := json.Marshal(*update.Attributes)
+		if err != nil {
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		set("attributes", string(attributes))
+	}
+	if update.PriceOverride != nil {
+		if *update.PriceOverride == 0 {
+			set("price_override", nil)
+		} else {
+			set("price_override", *update.PriceOverride)
+		}
+	}
+	if update.Images != nil {
+		set("images", pq.Array(*update.Images))
+		set("compressed_images", pq.Array([]string{}))
+	}
+	if len(sets) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var images []string
+	if update.Images != nil {
+		images = *update.Images
+	}
+	if !checkVariantImages(w, r, tx, productID, images) {
+		return
+	}
+
+	args = append(args, variantID, productID)
+	query := fmt.Sprintf("UPDATE product_variants SET %s WHERE variant_id = $%d AND product_id = $%d RETURNING %s",
+		strings.Join(sets, ", "), len(args)-1, len(args), variantColumns)
+
+	v, err := scanVariant(tx.QueryRowContext(r.Context(), query, args...))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Variant not found", http.StatusNotFound)
+		return
+	} else if isUniqueViolation(err) {
+		skuExists(w)
+		return
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	invalidateProductCache(r, productID)
+	if update.Images != nil {
+		publishVariantImageJobs(r, v)
+	}
+
+	utils.SendJSONResponse(w, v, http.StatusOK)
+	utils.Log(r.Context()).WithFields(logrus.Fields{"product_id": productID, "variant_id": variantID}).Info("Variant updated successfully")
+}
+
+// DeleteVariant deletes a variant. Its compressed images are shared with the product and are
+// left in place.
+func DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, ok := parseVariantPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := `WITH deleted AS (
+                  DELETE FROM product_variants v
+                  WHERE v.variant_id = $1 AND v.product_id = $2
+                  AND EXISTS (SELECT 1 FROM products p WHERE p.product_id = v.product_id AND p.deleted_at IS NULL)
+                  RETURNING v.product_id
+              )
+              UPDATE products SET version = version + 1 WHERE product_id IN (SELECT product_id FROM deleted)`
+
+	result, err := config.DB.ExecContext(r.Context(), query, variantID, productID)
+	if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Variant not found", http.StatusNotFound)
+		return
+	}
+
+	invalidateProductCache(r, productID)
+
+	w.WriteHeader(http.StatusNoContent)
+	utils.Log(r.Context()).WithFields(logrus.Fields{"product_id": productID, "variant_id": variantID}).Info("Variant deleted successfully")
+}
+
+// checkVariantImages bumps the product's version, which also locks it against concurrent
+// changes to its images, and checks that images are all among them. It writes a 404 or 422 and returns false otherwise.
+func checkVariantImages(w http.ResponseWriter, r *http.Request, tx *sql.Tx, productID int, images []string) bool {
+	var productImages []string
+	err := tx.QueryRowContext(r.Context(), "UPDATE products SET version = version + 1 WHERE product_id = $1 AND deleted_at IS NULL RETURNING product_images", productID).
+		Scan(pq.Array(&productImages))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		utils.HandleError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+
+	known := make(map[string]bool, len(productImages))
+	for _, image := range productImages {
+		known[image] = true
+	}
+	for _, image := range images {
+		if !known[image] {
+			utils.SendValidationError(w, r, &utils.ValidationError{Fields: []utils.FieldError{
+				{Field: "images", Message: "must only contain images of the product"},
+			}})
+			return false
+		}
+	}
+	return true
+}
+
+// publishVariantImageJobs queues the variant's images for compression. Failures are logged
+// rather than returned since the variant itself has already been saved.
+func publishVariantImageJobs(r *http.Request, v models.Variant) {
+	for _, imageURL := range v.Images {
+		err := config.Publisher.Publish(r.Context(), queue.ImageJob{
+			ProductID: v.ProductID,
+			VariantID: v.ID,
+			ImageURL:  imageURL,
+		})
+		if err != nil {
+			utils.Log(r.Context()).WithFields(logrus.Fields{
+				"product_id": v.ProductID,
+				"variant_id": v.ID,
+				"image_url":  imageURL,
+			}).WithError(err).Error("Failed to publish variant image for processing")
+		}
+	}
+}
+
+func skuExists(w http.ResponseWriter) {
+	http.Error(w, "A variant with this SKU already exists", http.StatusConflict)
+}
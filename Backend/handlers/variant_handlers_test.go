@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+	"backend/queue"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func variantRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"variant_id", "product_id", "sku", "attributes", "price_override", "images", "compressed_images", "stock", "reserved", "stock_version"})
+}
+
+func TestAddVariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	publisher := &mockPublisher{}
+	config.Publisher = publisher
+
+	t.Run("Created", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE products SET version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING product_images`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"product_images"}).AddRow(`{https://example.com/red.jpg,https://example.com/blue.jpg}`))
+		mock.ExpectQuery(`INSERT INTO product_variants \(product_id, sku, attributes, price_override, images, stock\)`).
+			WithArgs(21, "LAMP-RED-L", `{"colour":"red","size":"L"}`, "24.50", `{"https://example.com/red.jpg"}`, 3).
+			WillReturnRows(variantRows().AddRow(7, 21, "LAMP-RED-L", []byte(`{"size": "L", "colour": "red"}`), "24.50", `{https://example.com/red.jpg}`, `{}`, 3, 0, 0))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WithArgs(21, 7, "adjust", 3, nil, 3, 0, "initial stock", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"movement_id", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectCommit()
+		redisExpect.ExpectDel("product:21").SetVal(1)
+		expectOwnerLookup(mock, "{21}", "{1}")
+		expectListInvalidation(redisExpect, 1)
+
+		body := `{"sku": " LAMP-RED-L ", "attributes": {"colour": "red", "size": "L"}, "price_override": 24.5, "images": ["https://example.com/red.jpg"], "stock": 3}`
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants", strings.NewReader(body)), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.AddVariant(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisExpect.ExpectationsWereMet())
+		assert.Equal(t, []queue.ImageJob{{ProductID: 21, VariantID: 7, ImageURL: "https://example.com/red.jpg"}}, publisher.jobs)
+
+		var variant models.Variant
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&variant))
+		assert.Equal(t, 7, variant.ID)
+		assert.Equal(t, map[string]string{"colour": "red", "size": "L"}, variant.Attributes)
+		assert.Equal(t, models.Price(2450), *variant.PriceOverride)
+	})
+
+	t.Run("Server Owned Fields", func(t *testing.T) {
+		for _, field := range []string{
+			`"variant_id": 9`, `"product_id": 22`, `"compressed_images": []`, `"reserved": 2`,
+		} {
+			body := `{"sku": "LAMP-GREEN", ` + field + `}`
+			req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants", strings.NewReader(body)), 1)
+			req.SetPathValue("id", "21")
+			w := httptest.NewRecorder()
+
+			handlers.AddVariant(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, field)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Image Of Another Product", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE products SET version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING product_images`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"product_images"}).AddRow(`{https://example.com/red.jpg}`))
+		mock.ExpectRollback()
+
+		body := `{"sku": "LAMP-GREEN", "images": ["https://example.com/green.jpg"]}`
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants", strings.NewReader(body)), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.AddVariant(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"field":"images"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Duplicate SKU", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE products SET version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING product_images`).WithArgs(21).
+			WillReturnRows(sqlmock.NewRows([]string{"product_images"}).AddRow(`{}`))
+		mock.ExpectQuery(`INSERT INTO product_variants`).
+			WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+		mock.ExpectRollback()
+
+		req := withUser(httptest.NewRequest(http.MethodPost, "/products/21/variants", strings.NewReader(`{"sku": "LAMP-RED-L"}`)), 1)
+		req.SetPathValue("id", "21")
+		w := httptest.NewRecorder()
+
+		handlers.AddVariant(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateVariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	redisMock, redisExpect := redismock.NewClientMock()
+	useRedis(t, redisMock)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE products SET version = version \+ 1 WHERE product_id = \$1 AND deleted_at IS NULL RETURNING product_images`).WithArgs(21).
+		WillReturnRows(sqlmock.NewRows([]string{"product_images"}).AddRow(`{}`))
+	mock.ExpectQuery(`UPDATE product_variants SET price_override = \$1 WHERE variant_id = \$2 AND product_id = \$3 RETURNING`).
+		WithArgs(nil, 7, 21).
+		WillReturnRows(variantRows().AddRow(7, 21, "LAMP-RED-L", []byte(`{}`), nil, `{}`, `{}`, 0, 0, 0))
+	mock.ExpectCommit()
+	redisExpect.ExpectDel("product:21").SetVal(1)
+	expectOwnerLookup(mock, "{21}", "{1}")
+	expectListInvalidation(redisExpect, 1)
+
+	req := withUser(httptest.NewRequest(http.MethodPatch, "/products/21/variants/7", strings.NewReader(`{"price_override": 0}`)), 1)
+	req.SetPathValue("id", "21")
+	req.SetPathValue("variant_id", "7")
+	w := httptest.NewRecorder()
+
+	handlers.UpdateVariant(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Contains(t, w.Body.String(), `"price_override":null`)
+}
+
+func TestGetVariants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs(404).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req := httptest.NewRequest(http.MethodGet, "/products/404/variants", nil)
+	req.SetPathValue("id", "404")
+	w := httptest.NewRecorder()
+
+	handlers.GetVariants(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,121 @@
+// Package health serves the liveness and readiness probes of the Backend.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"backend/utils"
+)
+
+// Statuses reported by Ready, overall and per check.
+const (
+	StatusOK = "ok"
+	// StatusDegraded means a non-critical check failed; the Backend still serves requests.
+	StatusDegraded = "degraded"
+	// StatusUnavailable means a critical check failed.
+	StatusUnavailable = "unavailable"
+	StatusError       = "error"
+)
+
+// Check checks that a dependency is reachable.
+type Check struct {
+	Name string
+	// Critical checks make the Backend unready when they fail. The others only degrade it,
+	// for dependencies it falls back from, like the cache.
+	Critical bool
+	Func     func(ctx context.Context) error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the body of readiness responses.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Live answers liveness probes. It checks no dependencies, as restarting the Backend wouldn't
+// bring them back.
+func Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, map[string]string{"status": StatusOK}, http.StatusOK)
+}
+
+// Ready returns a handler for readiness probes. It runs checks concurrently, failing those
+// that take longer than timeout, and answers 503 if a critical one failed.
+func Ready(timeout time.Duration, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks...)
+		status := http.StatusOK
+		if report.Status == StatusUnavailable {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		utils.SendJSONResponse(w, report, status)
+	}
+}
+
+// Run runs checks concurrently, each with timeout, and reports their results.
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, timeout, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		switch {
+		case result.Status == StatusOK:
+		case check.Critical:
+			report.Status = StatusUnavailable
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run runs check, giving up after timeout even if the check ignores its context.
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Func(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Status:     StatusOK,
+		Critical:   check.Critical,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusError
+		result.Error = err.Error()
+	}
+	return result
+}
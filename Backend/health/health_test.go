@@ -0,0 +1,111 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/health"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func failing(ctx context.Context) error { return errors.New("connection refused") }
+
+// hanging ignores its context, like dependencies whose clients can't be cancelled.
+func hanging(ctx context.Context) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func TestLive(t *testing.T) {
+	w := httptest.NewRecorder()
+	health.Live(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []health.Check
+		wantCode   int
+		wantStatus string
+		wantErrors map[string]string
+	}{
+		{
+			name: "all ok",
+			checks: []health.Check{
+				{Name: "postgres", Critical: true, Func: ok},
+				{Name: "redis", Func: ok},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: health.StatusOK,
+		},
+		{
+			name: "non-critical failure",
+			checks: []health.Check{
+				{Name: "postgres", Critical: true, Func: ok},
+				{Name: "redis", Func: failing},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: health.StatusDegraded,
+			wantErrors: map[string]string{"redis": "connection refused"},
+		},
+		{
+			name: "critical failure",
+			checks: []health.Check{
+				{Name: "postgres", Critical: true, Func: failing},
+				{Name: "redis", Func: failing},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: health.StatusUnavailable,
+			wantErrors: map[string]string{"postgres": "connection refused", "redis": "connection refused"},
+		},
+		{
+			name: "timeout",
+			checks: []health.Check{
+				{Name: "postgres", Critical: true, Func: hanging},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: health.StatusUnavailable,
+			wantErrors: map[string]string{"postgres": context.DeadlineExceeded.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			w := httptest.NewRecorder()
+			health.Ready(50*time.Millisecond, tt.checks...)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Less(t, time.Since(start), 500*time.Millisecond)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.Equal(t, tt.wantStatus, report.Status)
+			require.Len(t, report.Checks, len(tt.checks))
+			for _, check := range tt.checks {
+				result := report.Checks[check.Name]
+				assert.Equal(t, check.Critical, result.Critical, check.Name)
+				if want, failed := tt.wantErrors[check.Name]; failed {
+					assert.Equal(t, health.StatusError, result.Status, check.Name)
+					assert.Equal(t, want, result.Error, check.Name)
+				} else {
+					assert.Equal(t, health.StatusOK, result.Status, check.Name)
+					assert.Empty(t, result.Error, check.Name)
+				}
+			}
+		})
+	}
+}
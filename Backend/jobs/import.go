@@ -0,0 +1,439 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/models"
+	"backend/queue"
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// importBatchSize bounds how many rows are imported per transaction. Each product takes 7
+// query parameters, well within Postgres' limit of 65535.
+const importBatchSize = 500
+
+// errJobTakenOver is returned when a stale job was claimed by another worker while this one
+// was still running it.
+var errJobTakenOver = errors.New("import job was taken over by another worker")
+
+// Importer runs product import jobs. Jobs are claimed with SKIP LOCKED, so any number of
+// Backend instances can run an Importer against the same database.
+type Importer struct {
+	DB        *sql.DB
+	Publisher queue.Publisher
+	// StaleAfter is how long a running job may go without progress before another worker
+	// takes it over, e.g. because the instance running it was restarted.
+	StaleAfter time.Duration
+	// OnImported, if set, is called after each batch with the products it added for userID,
+	// e.g. to invalidate caches of the products and of product lists.
+	OnImported func(ctx context.Context, userID int, productIDs []int)
+}
+
+// importJob is the state of a job needed to run it.
+type importJob struct {
+	ID            int
+	UserID        int
+	CreatedBy     int
+	Format        string
+	Payload       []byte
+	Attempt       int
+	ProcessedRows int
+	// RequestID is the ID of the request that queued the job, so that its image jobs can be
+	// traced back to it.
+	RequestID string
+}
+
+// Run checks for import jobs every interval until ctx is cancelled, running all pending jobs
+// before waiting again.
+func (im *Importer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			ran, err := im.ProcessNext(ctx)
+			if err != nil {
+				utils.Logger.WithError(err).Error("Failed to run import job")
+			}
+			if !ran || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims the oldest pending or stale import job and runs it. It reports whether
+// there was a job to run. A job that fails is marked failed, keeping the rows imported so far.
+func (im *Importer) ProcessNext(ctx context.Context) (bool, error) {
+	job, err := im.claim(ctx)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to claim import job: %v", err)
+	}
+
+	log := utils.Logger.WithFields(map[string]interface{}{"job_id": job.ID, "request_id": job.RequestID})
+	log.Info("Import job started")
+
+	err = im.process(ctx, job)
+	if err == errJobTakenOver || ctx.Err() != nil {
+		// The job is left running for whoever took it over, or for a takeover after a restart
+		return true, err
+	} else if err != nil {
+		_, ferr := im.DB.ExecContext(ctx, `UPDATE import_jobs SET status = $3, error = $4, payload = NULL,
+              finished_at = now(), updated_at = now() WHERE job_id = $1 AND attempt = $2`,
+			job.ID, job.Attempt, models.ImportFailed, err.Error())
+		if ferr != nil {
+			log.WithError(ferr).Error("Failed to mark import job as failed")
+		}
+		return true, fmt.Errorf("import job %d failed: %v", job.ID, err)
+	}
+
+	log.Info("Import job completed")
+	return true, nil
+}
+
+// claim marks the oldest pending job, or a running job that has made no progress for
+// StaleAfter, as running by this worker. Bumping attempt lets the previous worker of a stale
+// job notice it has been replaced.
+func (im *Importer) claim(ctx context.Context) (*importJob, error) {
+	query := `UPDATE import_jobs SET status = $1, attempt = attempt + 1,
+                  started_at = coalesce(started_at, now()), updated_at = now()
+              WHERE job_id = (
+                  SELECT job_id FROM import_jobs
+                  WHERE status = $2 OR (status = $1 AND updated_at < $3)
+                  ORDER BY job_id LIMIT 1 FOR UPDATE SKIP LOCKED
+              )
+              RETURNING job_id, user_id, created_by, format, payload, attempt, processed_rows, request_id`
+
+	var job importJob
+	err := im.DB.QueryRowContext(ctx, query, models.ImportRunning, models.ImportPending, time.Now().Add(-im.StaleAfter)).
+		Scan(&job.ID, &job.UserID, &job.CreatedBy, &job.Format, &job.Payload, &job.Attempt, &job.ProcessedRows, &job.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// process imports the job's rows in batches, skipping rows a previous worker already
+// processed, and marks the job completed.
+func (im *Importer) process(ctx context.Context, job *importJob) error {
+	rows, err := newRowReader(job.Format, job.Payload)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < job.ProcessedRows; i++ {
+		if _, err := rows.next(); err != nil {
+			return fmt.Errorf("failed to skip processed rows: %v", err)
+		}
+	}
+
+	for {
+		batch, err := readBatch(rows, job.UserID)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := im.importBatch(ctx, job, batch); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	result, err := im.DB.ExecContext(ctx, `UPDATE import_jobs SET status = $3, payload = NULL,
+              finished_at = now(), updated_at = now() WHERE job_id = $1 AND attempt = $2`,
+		job.ID, job.Attempt, models.ImportCompleted)
+	if err != nil {
+		return fmt.Errorf("failed to complete import job: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errJobTakenOver
+	}
+	return nil
+}
+
+// readBatch reads up to importBatchSize rows and validates the products they describe the
+// same way POST /products/add does. It returns io.EOF with the last rows of the upload.
+func readBatch(rows rowReader, userID int) ([]importRow, error) {
+	batch := make([]importRow, 0, importBatchSize)
+	for len(batch) < importBatchSize {
+		row, err := rows.next()
+		if err != nil {
+			return batch, err
+		}
+
+		if len(row.Errors) == 0 {
+			rec := row.Record
+			row.Product = models.Product{
+				UserID:             userID,
+				ProductName:        rec.ProductName,
+				ProductDescription: rec.ProductDescription,
+				ProductImages:      rec.ProductImages,
+				ProductPrice:       rec.ProductPrice,
+				Currency:           rec.Currency,
+				Stock:              rec.Stock,
+				CategoryIDs:        rec.CategoryIDs,
+				Tags:               rec.Tags,
+			}
+			row.Product.Normalize()
+			if err := utils.Validate(&row.Product); err != nil {
+				var verr *utils.ValidationError
+				if !errors.As(err, &verr) {
+					return nil, err
+				}
+				for _, f := range verr.Fields {
+					row.reject(f.Field, f.Message)
+				}
+			}
+		}
+		batch = append(batch, row)
+	}
+	return batch, nil
+}
+
+// importBatch inserts the valid products of a batch with their categories, tags and initial
+// stock, records the errors of the others and advances the job's progress in one
+// transaction, then queues the new products' images for compression.
+func (im *Importer) importBatch(ctx context.Context, job *importJob, batch []importRow) error {
+	tx, err := im.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCategories(ctx, tx, batch); err != nil {
+		return err
+	}
+
+	var products []*models.Product
+	var rowErrors []models.ImportError
+	failed := 0
+	for i := range batch {
+		if len(batch[i].Errors) > 0 {
+			rowErrors = append(rowErrors, batch[i].Errors...)
+			failed++
+		} else {
+			products = append(products, &batch[i].Product)
+		}
+	}
+
+	if len(products) > 0 {
+		if err := insertProducts(ctx, tx, products); err != nil {
+			return err
+		}
+		if err := insertProductDetails(ctx, tx, products, job.CreatedBy); err != nil {
+			return err
+		}
+	}
+	if err := insertImportErrors(ctx, tx, job.ID, rowErrors); err != nil {
+		return err
+	}
+
+	// Updating the job also serves as its heartbeat, keeping other workers from taking it over
+	result, err := tx.ExecContext(ctx, `UPDATE import_jobs SET processed_rows = processed_rows + $3,
+                  imported_rows = imported_rows + $4, failed_rows = failed_rows + $5, updated_at = now()
+              WHERE job_id = $1 AND attempt = $2`,
+		job.ID, job.Attempt, len(batch), len(products), failed)
+	if err != nil {
+		return fmt.Errorf("failed to record import progress: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errJobTakenOver
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	job.ProcessedRows += len(batch)
+	if len(products) > 0 && im.OnImported != nil {
+		productIDs := make([]int, len(products))
+		for i, p := range products {
+			productIDs[i] = p.ID
+		}
+		im.OnImported(ctx, job.UserID, productIDs)
+	}
+
+	var imageJobs []queue.ImageJob
+	for _, p := range products {
+		for _, imageURL := range p.ProductImages {
+			imageJobs = append(imageJobs, queue.ImageJob{ProductID: p.ID, ImageURL: imageURL, RequestID: job.RequestID})
+		}
+	}
+	if err := im.Publisher.PublishBatch(ctx, imageJobs); err != nil {
+		utils.Logger.WithFields(map[string]interface{}{"job_id": job.ID, "request_id": job.RequestID}).WithError(err).Error("Failed to publish imported images for processing")
+	}
+	return nil
+}
+
+// checkCategories rejects rows assigned to categories that do not exist. The categories
+// that do exist are locked until the batch commits so they cannot be deleted in between.
+func checkCategories(ctx context.Context, tx *sql.Tx, batch []importRow) error {
+	var ids []int
+	for _, row := range batch {
+		if len(row.Errors) == 0 {
+			ids = append(ids, row.Product.CategoryIDs...)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT category_id FROM categories WHERE category_id = ANY($1) FOR KEY SHARE",
+		pq.Array(models.DedupeIDs(ids)))
+	if err != nil {
+		return fmt.Errorf("failed to look up categories: %v", err)
+	}
+	defer rows.Close()
+
+	exists := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan category: %v", err)
+		}
+		exists[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to look up categories: %v", err)
+	}
+
+	for i := range batch {
+		if len(batch[i].Errors) > 0 {
+			continue
+		}
+		for _, id := range batch[i].Product.CategoryIDs {
+			if !exists[id] {
+				batch[i].reject("category_ids", "contains a category that does not exist")
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// insertProducts inserts products with a single statement and sets their IDs.
+func insertProducts(ctx context.Context, tx *sql.Tx, products []*models.Product) error {
+	values := make([]string, 0, len(products))
+	args := make([]interface{}, 0, 7*len(products))
+	for _, p := range products {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, '{}', $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, p.UserID, p.ProductName, p.ProductDescription, pq.Array(p.ProductImages),
+			p.ProductPrice, p.Currency, p.Stock)
+	}
+
+	query := `INSERT INTO products (user_id, product_name, product_description, product_images, compressed_product_images, product_price, currency, stock)
+              VALUES ` + strings.Join(values, ", ") + ` RETURNING product_id`
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert products: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0, len(products))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan product ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to insert products: %v", err)
+	}
+	if len(ids) != len(products) {
+		return fmt.Errorf("inserted %d products, expected %d", len(ids), len(products))
+	}
+
+	// product_id is drawn from the sequence row by row in VALUES order, so the sorted IDs line
+	// up with the products whatever order RETURNING lists them in
+	sort.Ints(ids)
+	for i, p := range products {
+		p.ID = ids[i]
+	}
+	return nil
+}
+
+// insertProductDetails assigns the categories and tags of newly inserted products and records
+// their initial stock in the stock ledger, attributed to the user who ran the import.
+func insertProductDetails(ctx context.Context, tx *sql.Tx, products []*models.Product, userID int) error {
+	var categoryProducts, categoryIDs, tagProducts, stockProducts, stocks []int
+	var tags []string
+	for _, p := range products {
+		for _, id := range p.CategoryIDs {
+			categoryProducts = append(categoryProducts, p.ID)
+			categoryIDs = append(categoryIDs, id)
+		}
+		for _, tag := range p.Tags {
+			tagProducts = append(tagProducts, p.ID)
+			tags = append(tags, tag)
+		}
+		if p.Stock != 0 {
+			stockProducts = append(stockProducts, p.ID)
+			stocks = append(stocks, p.Stock)
+		}
+	}
+
+	if len(categoryIDs) > 0 {
+		_, err := tx.ExecContext(ctx, "INSERT INTO product_categories (product_id, category_id) SELECT * FROM unnest($1::integer[], $2::integer[])",
+			pq.Array(categoryProducts), pq.Array(categoryIDs))
+		if err != nil {
+			return fmt.Errorf("failed to assign product categories: %v", err)
+		}
+	}
+	if len(tags) > 0 {
+		_, err := tx.ExecContext(ctx, "INSERT INTO product_tags (product_id, tag) SELECT * FROM unnest($1::integer[], $2::text[])",
+			pq.Array(tagProducts), pq.Array(tags))
+		if err != nil {
+			return fmt.Errorf("failed to assign product tags: %v", err)
+		}
+	}
+	if len(stocks) > 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO stock_movements (product_id, kind, quantity, stock_after, reserved_after, reason, user_id)
+              SELECT product_id, $3, stock, stock, 0, $4, $5 FROM unnest($1::integer[], $2::integer[]) AS t(product_id, stock)`,
+			pq.Array(stockProducts), pq.Array(stocks), models.MovementAdjust, "initial stock", userID)
+		if err != nil {
+			return fmt.Errorf("failed to record initial stock: %v", err)
+		}
+	}
+	return nil
+}
+
+// insertImportErrors adds rowErrors to the job's error report.
+func insertImportErrors(ctx context.Context, tx *sql.Tx, jobID int, rowErrors []models.ImportError) error {
+	if len(rowErrors) == 0 {
+		return nil
+	}
+	rowNumbers := make([]int, len(rowErrors))
+	fields := make([]string, len(rowErrors))
+	messages := make([]string, len(rowErrors))
+	for i, e := range rowErrors {
+		rowNumbers[i], fields[i], messages[i] = e.Row, e.Field, e.Message
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO import_errors (job_id, row_number, field, message)
+              SELECT $1, * FROM unnest($2::integer[], $3::text[], $4::text[])`,
+		jobID, pq.Array(rowNumbers), pq.Array(fields), pq.Array(messages))
+	if err != nil {
+		return fmt.Errorf("failed to record import errors: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"backend/models"
+	"backend/utils"
+)
+
+// importRecord holds the fields a product can be imported with. Both formats are decoded into
+// it, so an NDJSON line is a POST /products/add body without user_id.
+type importRecord struct {
+	ProductName        string       `json:"product_name"`
+	ProductDescription string       `json:"product_description"`
+	ProductImages      []string     `json:"product_images"`
+	ProductPrice       models.Price `json:"product_price"`
+	Currency           string       `json:"currency"`
+	Stock              int          `json:"stock"`
+	CategoryIDs        []int        `json:"category_ids"`
+	Tags               []string     `json:"tags"`
+}
+
+// importRow is one row of an upload. Product is only meaningful if there are no Errors.
+type importRow struct {
+	Number  int
+	Record  importRecord
+	Product models.Product
+	Errors  []models.ImportError
+}
+
+func (row *importRow) reject(field, message string) {
+	row.Errors = append(row.Errors, models.ImportError{Row: row.Number, Field: field, Message: message})
+}
+
+// rowReader reads the rows of an upload. next returns io.EOF after the last row; any other
+// error means the rest of the upload cannot be read. Malformed rows are not errors but are
+// returned with their Errors set.
+type rowReader interface {
+	next() (importRow, error)
+}
+
+// csvColumns are the columns a CSV upload may have. Lists are separated by "|".
+var csvColumns = []string{"product_name", "product_description", "product_images", "product_price",
+	"currency", "stock", "category_ids", "tags"}
+
+func newRowReader(format string, payload []byte) (rowReader, error) {
+	switch format {
+	case models.ImportCSV:
+		return newCSVRowReader(payload)
+	case models.ImportNDJSON:
+		return newNDJSONRowReader(payload), nil
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// CountImportRows checks that payload can be read as format and counts its rows, including
+// malformed ones, which are only reported once the import runs.
+func CountImportRows(format string, payload []byte) (int, error) {
+	rows, err := newRowReader(format, payload)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		if _, err := rows.next(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
+type csvRowReader struct {
+	r       *csv.Reader
+	columns []string
+	n       int
+}
+
+// newCSVRowReader reads the header row, which must name the columns of the upload in any
+// order and include at least product_name and product_price.
+func newCSVRowReader(payload []byte) (*csvRowReader, error) {
+	r := csv.NewReader(bytes.NewReader(payload))
+	// Rows with the wrong number of fields are reported per row rather than by the reader
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV upload must start with a header row")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+
+	seen := make(map[string]bool, len(header))
+	columns := make([]string, len(header))
+	for i, name := range header {
+		// Spreadsheet exports often start with a byte order mark
+		column := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !isCSVColumn(column) {
+			return nil, fmt.Errorf("unknown CSV column %q; columns are %s", name, strings.Join(csvColumns, ", "))
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("duplicate CSV column %q", name)
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	for _, required := range []string{"product_name", "product_price"} {
+		if !seen[required] {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	return &csvRowReader{r: r, columns: columns}, nil
+}
+
+func isCSVColumn(name string) bool {
+	for _, column := range csvColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *csvRowReader) next() (importRow, error) {
+	fields, err := c.r.Read()
+	if err == io.EOF {
+		return importRow{}, io.EOF
+	}
+	c.n++
+	row := importRow{Number: c.n}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.reject("", parseErr.Err.Error())
+		return row, nil
+	} else if err != nil {
+		return importRow{}, err
+	}
+	if len(fields) != len(c.columns) {
+		row.reject("", fmt.Sprintf("has %d fields, expected %d", len(fields), len(c.columns)))
+		return row, nil
+	}
+
+	rec := &row.Record
+	for i, value := range fields {
+		column := c.columns[i]
+		switch column {
+		case "product_name":
+			rec.ProductName = value
+		case "product_description":
+			rec.ProductDescription = value
+		case "product_images":
+			rec.ProductImages = splitList(value)
+		case "product_price":
+			if strings.TrimSpace(value) == "" {
+				break
+			}
+			price, err := models.ParsePrice(value)
+			if err != nil {
+				row.reject(column, fmt.Sprintf("must be a decimal number with at most %d decimal places", models.PriceScale))
+			}
+			rec.ProductPrice = price
+		case "currency":
+			rec.Currency = value
+		case "stock":
+			if strings.TrimSpace(value) == "" {
+				break
+			}
+			stock, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				row.reject(column, "must be a whole number")
+			}
+			rec.Stock = stock
+		case "category_ids":
+			for _, s := range splitList(value) {
+				id, err := strconv.Atoi(s)
+				if err != nil {
+					row.reject(column, `must be category IDs separated by "|"`)
+					break
+				}
+				rec.CategoryIDs = append(rec.CategoryIDs, id)
+			}
+		case "tags":
+			rec.Tags = splitList(value)
+		}
+	}
+	return row, nil
+}
+
+// splitList splits a "|" separated CSV field, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type ndjsonRowReader struct {
+	s    *bufio.Scanner
+	line int
+	n    int
+}
+
+// newNDJSONRowReader reads one JSON object per line. Blank lines are skipped.
+func newNDJSONRowReader(payload []byte) *ndjsonRowReader {
+	s := bufio.NewScanner(bytes.NewReader(payload))
+	s.Buffer(make([]byte, 0, 64*1024), utils.MaxRequestBodyBytes)
+	return &ndjsonRowReader{s: s}
+}
+
+func (j *ndjsonRowReader) next() (importRow, error) {
+	for j.s.Scan() {
+		j.line++
+		line := bytes.TrimSpace(j.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		j.n++
+		row := importRow{Number: j.n}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&row.Record)
+		if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+			err = errors.New("line must contain a single JSON object")
+		}
+		if err != nil {
+			row.reject("", err.Error())
+		}
+		return row, nil
+	}
+
+	if err := j.s.Err(); err == bufio.ErrTooLong {
+		return importRow{}, fmt.Errorf("line %d is longer than %d bytes", j.line+1, utils.MaxRequestBodyBytes)
+	} else if err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
@@ -0,0 +1,146 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/jobs"
+	"backend/queue"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublisher struct {
+	jobs []queue.ImageJob
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, job queue.ImageJob) error {
+	p.jobs = append(p.jobs, job)
+	return nil
+}
+
+func (p *fakePublisher) PublishBatch(ctx context.Context, jobs []queue.ImageJob) error {
+	p.jobs = append(p.jobs, jobs...)
+	return nil
+}
+
+var claimColumns = []string{"job_id", "user_id", "created_by", "format", "payload", "attempt", "processed_rows", "request_id"}
+
+func TestImportProcessNext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	publisher := &fakePublisher{}
+	var imported []int
+	var importedProducts []int
+	importer := &jobs.Importer{DB: db, Publisher: publisher, StaleAfter: time.Minute,
+		OnImported: func(ctx context.Context, userID int, productIDs []int) {
+			imported = append(imported, userID)
+			importedProducts = append(importedProducts, productIDs...)
+		}}
+
+	payload := "product_name,product_price,product_images,category_ids,tags,stock\n" +
+		"Lamp,19.99,https://example.com/lamp.jpg,3,Home|home ,5\n" +
+		",10,,,,\n" +
+		"Desk,abc,,,,\n" +
+		"Chair,50,,7,,\n"
+
+	mock.ExpectQuery(`UPDATE import_jobs SET status = \$1, attempt = attempt \+ 1`).
+		WithArgs("running", "pending", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(1, 5, 8, "csv", []byte(payload), 1, 0, "req-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT category_id FROM categories WHERE category_id = ANY\(\$1\) FOR KEY SHARE`).WithArgs("{3,7}").
+		WillReturnRows(sqlmock.NewRows([]string{"category_id"}).AddRow(3))
+	mock.ExpectQuery(`INSERT INTO products .* VALUES \(\$1, \$2, \$3, \$4, '\{\}', \$5, \$6, \$7\) RETURNING product_id`).
+		WithArgs(5, "Lamp", "", `{"https://example.com/lamp.jpg"}`, "19.99", "USD", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(40))
+	mock.ExpectExec(`INSERT INTO product_categories`).WithArgs("{40}", "{3}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO product_tags`).WithArgs("{40}", `{"home"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_movements`).WithArgs("{40}", "{5}", "adjust", "initial stock", 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO import_errors`).
+		WithArgs(1, "{2,3,4}", `{"product_name","product_price","category_ids"}`,
+			`{"is required","must be a decimal number with at most 2 decimal places","contains a category that does not exist"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE import_jobs SET processed_rows = processed_rows \+ \$3`).WithArgs(1, 1, 4, 1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE import_jobs SET status = \$3, payload = NULL`).WithArgs(1, 1, "completed").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ran, err := importer.ProcessNext(context.Background())
+
+	assert.True(t, ran)
+	assert.NoError(t, err)
+	assert.Equal(t, []queue.ImageJob{{ProductID: 40, ImageURL: "https://example.com/lamp.jpg", RequestID: "req-1"}}, publisher.jobs)
+	assert.Equal(t, []int{5}, imported)
+	assert.Equal(t, []int{40}, importedProducts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportProcessNextResumesTakenOverJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	importer := &jobs.Importer{DB: db, Publisher: &fakePublisher{}, StaleAfter: time.Minute}
+
+	// The first row was imported by the worker that crashed
+	payload := `{"product_name": "Lamp", "product_price": 19.99}` + "\n" +
+		`{"product_name": "Desk", "product_price": 120, "user_id": 2}` + "\n"
+
+	mock.ExpectQuery(`UPDATE import_jobs SET status`).
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(2, 5, 5, "ndjson", []byte(payload), 2, 1, ""))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO import_errors`).
+		WithArgs(2, "{2}", `{""}`, `{"json: unknown field \"user_id\""}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE import_jobs SET processed_rows`).WithArgs(2, 2, 1, 0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE import_jobs SET status = \$3`).WithArgs(2, 2, "completed").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ran, err := importer.ProcessNext(context.Background())
+
+	assert.True(t, ran)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportProcessNextWithoutJobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	importer := &jobs.Importer{DB: db, Publisher: &fakePublisher{}, StaleAfter: time.Minute}
+
+	mock.ExpectQuery(`UPDATE import_jobs SET status`).WillReturnRows(sqlmock.NewRows(claimColumns))
+
+	ran, err := importer.ProcessNext(context.Background())
+
+	assert.False(t, ran)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountImportRows(t *testing.T) {
+	n, err := jobs.CountImportRows("csv", []byte("\ufeffProduct_Name,product_price\nLamp,1\n\nDesk\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = jobs.CountImportRows("csv", []byte("product_name\nLamp\n"))
+	assert.EqualError(t, err, "CSV header is missing the product_price column")
+
+	_, err = jobs.CountImportRows("csv", []byte(""))
+	assert.EqualError(t, err, "CSV upload must start with a header row")
+
+	n, err = jobs.CountImportRows("ndjson", []byte("{}\n\n  \nnot json\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"backend/storage"
+	"backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// purgeBatchSize bounds how many products are purged per query.
+const purgeBatchSize = 100
+
+// PurgeResult counts the rows removed by a purge run.
+type PurgeResult struct {
+	Products int
+	Users    int
+}
+
+// Purger permanently removes products and users that were soft deleted more than Retention
+// ago, along with the compressed images the microservice uploaded for those products and
+// their variants.
+type Purger struct {
+	DB        *sql.DB
+	Images    storage.ImageStore
+	Retention time.Duration
+}
+
+// Run purges every Interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := p.PurgeOnce(ctx)
+		if err != nil {
+			utils.Logger.WithError(err).Error("Failed to purge soft-deleted rows")
+		} else if res.Products > 0 || res.Users > 0 {
+			utils.Logger.WithFields(map[string]interface{}{
+				"products": res.Products,
+				"users":    res.Users,
+			}).Info("Purged soft-deleted rows")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce removes every expired soft-deleted row. Products are purged first so that users
+// whose products have all been purged can be removed in the same run.
+func (p *Purger) PurgeOnce(ctx context.Context) (PurgeResult, error) {
+	var res PurgeResult
+	cutoff := time.Now().Add(-p.Retention)
+
+	for {
+		n, err := p.purgeProductBatch(ctx, cutoff)
+		res.Products += n
+		if err != nil {
+			return res, err
+		}
+		if n < purgeBatchSize {
+			break
+		}
+	}
+
+	n, err := p.purgeUsers(ctx, cutoff)
+	res.Users = n
+	return res, err
+}
+
+// purgeProductBatch deletes the images of up to purgeBatchSize expired products and their
+// variants that no other product uses and then the products themselves. Images are deleted first so a failure leaves the rows in place to be
+// retried rather than orphaning objects in the bucket.
+func (p *Purger) purgeProductBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	query := `SELECT p.product_id, p.compressed_product_images || ARRAY(
+                  SELECT unnest(v.compressed_images) FROM product_variants v WHERE v.product_id = p.product_id
+              ) FROM products p
+              WHERE p.deleted_at < $1 ORDER BY p.product_id LIMIT $2`
+
+	rows, err := p.DB.QueryContext(ctx, query, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired products: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var images []string
+	for rows.Next() {
+		var id int64
+		var compressed []string
+		if err := rows.Scan(&id, pq.Array(&compressed)); err != nil {
+			return 0, fmt.Errorf("failed to scan expired product: %v", err)
+		}
+		ids = append(ids, id)
+		images = append(images, compressed...)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to find expired products: %v", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	images, err = p.unreferencedImages(ctx, ids, images)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.Images.DeleteImages(ctx, images); err != nil {
+		return 0, err
+	}
+
+	// Restores are refused once deleted_at passes the cutoff, so these rows can't come back
+	result, err := p.DB.ExecContext(ctx, "DELETE FROM products WHERE product_id = ANY($1) AND deleted_at < $2",
+		pq.Array(ids), cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired products: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// unreferencedImages returns the images that no product or variant outside ids still uses.
+// The microservice names compressed objects after their source image, so products created
+// from the same image share one object.
+func (p *Purger) unreferencedImages(ctx context.Context, ids []int64, images []string) ([]string, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	query := `SELECT image FROM unnest($1::text[]) AS image
+              EXCEPT
+              SELECT unnest(compressed_product_images) FROM products
+              WHERE product_id <> ALL($2::int[]) AND compressed_product_images && $1::text[]
+              EXCEPT
+              SELECT unnest(compressed_images) FROM product_variants
+              WHERE product_id <> ALL($2::int[]) AND compressed_images && $1::text[]`
+
+	rows, err := p.DB.QueryContext(ctx, query, pq.Array(images), pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unreferenced images: %v", err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var image string
+		if err := rows.Scan(&image); err != nil {
+			return nil, fmt.Errorf("failed to scan unreferenced image: %v", err)
+		}
+		unreferenced = append(unreferenced, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find unreferenced images: %v", err)
+	}
+	return unreferenced, nil
+}
+
+// purgeUsers deletes expired users that no longer own any products, with their API keys.
+func (p *Purger) purgeUsers(ctx context.Context, cutoff time.Time) (int, error) {
+	query := `WITH expired AS (
+                  SELECT user_id FROM users u
+                  WHERE u.deleted_at < $1
+                  AND NOT EXISTS (SELECT 1 FROM products p WHERE p.user_id = u.user_id)
+              ), keys AS (
+                  DELETE FROM api_keys WHERE user_id IN (SELECT user_id FROM expired)
+              )
+              DELETE FROM users WHERE user_id IN (SELECT user_id FROM expired)`
+
+	result, err := p.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired users: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
@@ -0,0 +1,116 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/jobs"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expiredProductsQuery matches the query collecting expired products with the compressed images
+// of the products and their variants.
+const expiredProductsQuery = `SELECT p.product_id, p.compressed_product_images \|\| ARRAY\(\s+SELECT unnest\(v.compressed_images\) FROM product_variants v`
+
+type fakeImageStore struct {
+	deleted []string
+	err     error
+}
+
+func (s *fakeImageStore) DeleteImages(ctx context.Context, urls []string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.deleted = append(s.deleted, urls...)
+	return nil
+}
+
+func TestPurgeOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	images := &fakeImageStore{}
+	purger := &jobs.Purger{DB: db, Images: images, Retention: 24 * time.Hour}
+
+	// c.jpg is the compressed image of a variant of product 2
+	mock.ExpectQuery(expiredProductsQuery).
+		WithArgs(sqlmock.AnyArg(), 100).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "images"}).
+			AddRow(1, `{"https://bucket.s3.amazonaws.com/a.jpg"}`).
+			AddRow(2, `{"https://bucket.s3.amazonaws.com/b.jpg","https://bucket.s3.amazonaws.com/c.jpg"}`))
+	mock.ExpectQuery("SELECT image FROM unnest").
+		WillReturnRows(sqlmock.NewRows([]string{"image"}).
+			AddRow("https://bucket.s3.amazonaws.com/a.jpg").
+			AddRow("https://bucket.s3.amazonaws.com/b.jpg").
+			AddRow("https://bucket.s3.amazonaws.com/c.jpg"))
+	mock.ExpectExec(`DELETE FROM products WHERE product_id = ANY\(\$1\) AND deleted_at < \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM users WHERE user_id IN").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := purger.PurgeOnce(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.PurgeResult{Products: 2, Users: 1}, res)
+	assert.Equal(t, []string{
+		"https://bucket.s3.amazonaws.com/a.jpg",
+		"https://bucket.s3.amazonaws.com/b.jpg",
+		"https://bucket.s3.amazonaws.com/c.jpg",
+	}, images.deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeOnceKeepsRowsWhenImageDeletionFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	purger := &jobs.Purger{DB: db, Images: &fakeImageStore{err: errors.New("s3 unavailable")}, Retention: time.Hour}
+
+	mock.ExpectQuery(expiredProductsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "images"}).
+			AddRow(1, `{"https://bucket.s3.amazonaws.com/a.jpg"}`))
+	mock.ExpectQuery("SELECT image FROM unnest").
+		WillReturnRows(sqlmock.NewRows([]string{"image"}).AddRow("https://bucket.s3.amazonaws.com/a.jpg"))
+
+	_, err = purger.PurgeOnce(context.Background())
+
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeOnceKeepsSharedImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	images := &fakeImageStore{}
+	purger := &jobs.Purger{DB: db, Images: images, Retention: time.Hour}
+
+	// Product 1 is purged; product 2 was created from the same source image and is kept
+	mock.ExpectQuery(expiredProductsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "images"}).
+			AddRow(1, `{"https://bucket.s3.amazonaws.com/shared.jpg_compressed.jpg","https://bucket.s3.amazonaws.com/own.jpg_compressed.jpg"}`))
+	mock.ExpectQuery(`SELECT image FROM unnest\(\$1::text\[\]\) AS image\s+EXCEPT\s+SELECT unnest\(compressed_product_images\) FROM products\s+WHERE product_id <> ALL\(\$2::int\[\]\)`).
+		WithArgs(
+			`{"https://bucket.s3.amazonaws.com/shared.jpg_compressed.jpg","https://bucket.s3.amazonaws.com/own.jpg_compressed.jpg"}`,
+			"{1}",
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"image"}).AddRow("https://bucket.s3.amazonaws.com/own.jpg_compressed.jpg"))
+	mock.ExpectExec(`DELETE FROM products WHERE product_id = ANY\(\$1\) AND deleted_at < \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM users WHERE user_id IN").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	res, err := purger.PurgeOnce(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.PurgeResult{Products: 1}, res)
+	assert.Equal(t, []string{"https://bucket.s3.amazonaws.com/own.jpg_compressed.jpg"}, images.deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
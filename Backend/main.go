@@ -1,26 +1,101 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"backend/config"
 	"backend/handlers"
+	"backend/health"
+	"backend/jobs"
+	"backend/metrics"
 	"backend/middleware"
+	"backend/policy"
 	"backend/utils"
 )
 
+// public wraps routes that can be called with or without credentials.
+func public(route string, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return middleware.Trace(route, middleware.RequestID(middleware.Measure(route, middleware.LogRequest(middleware.Compress(middleware.Recover(middleware.Timeout(route, middleware.RateLimit(route, middleware.OptionalAuthenticate(handlerFunc)))))))))
+}
+
+// protected wraps routes that require credentials and permission to perform action.
+func protected(route string, action policy.Action, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return middleware.Trace(route, middleware.RequestID(middleware.Measure(route, middleware.LogRequest(middleware.Compress(middleware.Recover(middleware.Timeout(route, middleware.RateLimit(route, middleware.Authenticate(middleware.Authorize(action, handlerFunc))))))))))
+}
+
 func main() {
 	// Initialize configuration
 	config.Init()
 
 	// Set up routes
-	http.HandleFunc("/users", middleware.LogRequest(handlers.GetUsers))
-	http.HandleFunc("/users/add", middleware.LogRequest(handlers.AddUser))
+	http.HandleFunc("/users", protected("users:list", policy.ListUsers, handlers.GetUsers))
+	http.HandleFunc("POST /users/add", public("users:create", handlers.AddUser))
+	http.HandleFunc("GET /users/{id}", protected("users:read", policy.ReadUser, handlers.GetUser))
+	http.HandleFunc("PATCH /users/{id}", protected("users:update", policy.UpdateUser, handlers.UpdateUser))
+	http.HandleFunc("DELETE /users/{id}", protected("users:delete", policy.DeleteUser, handlers.DeleteUser))
+	http.HandleFunc("POST /users/{id}/restore", protected("users:restore", policy.RestoreUser, handlers.RestoreUser))
+	http.HandleFunc("GET /users/{id}/products", public("users:products", handlers.GetUserProducts))
+
+	http.HandleFunc("/auth/token", protected("auth:token", policy.IssueToken, handlers.IssueToken))
+
+	http.HandleFunc("GET /categories", public("categories:list", handlers.GetCategories))
+	http.HandleFunc("POST /categories", protected("categories:create", policy.CreateCategory, handlers.AddCategory))
+	http.HandleFunc("GET /categories/{id}", public("categories:get", handlers.GetCategory))
+	http.HandleFunc("PATCH /categories/{id}", protected("categories:update", policy.UpdateCategory, handlers.UpdateCategory))
+	http.HandleFunc("DELETE /categories/{id}", protected("categories:delete", policy.DeleteCategory, handlers.DeleteCategory))
+
+	http.HandleFunc("/products", public("products:list", handlers.GetProducts))
+	http.HandleFunc("GET /products/export", public("products:export", handlers.ExportProducts))
+	http.HandleFunc("GET /products/facets", public("products:facets", handlers.GetProductFacets))
+	http.HandleFunc("POST /products/add", protected("products:create", policy.CreateProduct, handlers.AddProduct))
+	http.HandleFunc("POST /products/import", protected("products:import", policy.ImportProducts, handlers.ImportProducts))
+	http.HandleFunc("GET /products/{id}", public("products:get", handlers.GetProductByID))
+	http.HandleFunc("PATCH /products/{id}", protected("products:update", policy.UpdateProduct, handlers.UpdateProduct))
+	http.HandleFunc("DELETE /products/{id}", protected("products:delete", policy.DeleteProduct, handlers.DeleteProduct))
+	http.HandleFunc("POST /products/{id}/restore", protected("products:restore", policy.RestoreProduct, handlers.RestoreProduct))
+	http.HandleFunc("GET /products/{id}/variants", public("variants:list", handlers.GetVariants))
+	http.HandleFunc("POST /products/{id}/variants", protected("variants:create", policy.CreateVariant, handlers.AddVariant))
+	http.HandleFunc("GET /products/{id}/variants/{variant_id}", public("variants:get", handlers.GetVariant))
+	http.HandleFunc("PATCH /products/{id}/variants/{variant_id}", protected("variants:update", policy.UpdateVariant, handlers.UpdateVariant))
+	http.HandleFunc("DELETE /products/{id}/variants/{variant_id}", protected("variants:delete", policy.DeleteVariant, handlers.DeleteVariant))
+
+	http.HandleFunc("GET /products/{id}/inventory", public("inventory:get", handlers.GetInventory))
+	http.HandleFunc("POST /products/{id}/inventory/adjust", protected("inventory:adjust", policy.AdjustStock, handlers.AdjustStock))
+	http.HandleFunc("POST /products/{id}/inventory/reserve", protected("inventory:reserve", policy.ReserveStock, handlers.ReserveStock))
+	http.HandleFunc("POST /products/{id}/inventory/release", protected("inventory:release", policy.ReleaseStock, handlers.ReleaseStock))
+	http.HandleFunc("GET /products/{id}/inventory/movements", protected("inventory:ledger", policy.ViewLedger, handlers.GetStockMovements))
+	http.HandleFunc("GET /products/{id}/variants/{variant_id}/inventory", public("inventory:get", handlers.GetInventory))
+	http.HandleFunc("POST /products/{id}/variants/{variant_id}/inventory/adjust", protected("inventory:adjust", policy.AdjustStock, handlers.AdjustStock))
+	http.HandleFunc("POST /products/{id}/variants/{variant_id}/inventory/reserve", protected("inventory:reserve", policy.ReserveStock, handlers.ReserveStock))
+	http.HandleFunc("POST /products/{id}/variants/{variant_id}/inventory/release", protected("inventory:release", policy.ReleaseStock, handlers.ReleaseStock))
+
+	http.HandleFunc("GET /imports/{id}", protected("imports:view", policy.ViewImport, handlers.GetImportJob))
+	http.HandleFunc("GET /imports/{id}/errors", protected("imports:errors", policy.ViewImport, handlers.GetImportErrors))
+
+	http.HandleFunc("GET /cache/stats", protected("cache:stats", policy.ViewCacheStats, handlers.GetCacheStats))
+	http.HandleFunc("GET /healthz", health.Live)
+	http.HandleFunc("GET /readyz", health.Ready(config.HealthCheckTimeout, config.HealthChecks...))
+
+	// Purge expired soft-deleted rows in the background
+	purger := &jobs.Purger{DB: config.DB, Images: config.ImageStore, Retention: config.SoftDeleteRetention}
+	go purger.Run(context.Background(), config.PurgeInterval)
+
+	// Run product imports in the background
+	importer := &jobs.Importer{DB: config.DB, Publisher: config.Publisher, StaleAfter: config.ImportStaleAfter,
+		OnImported: handlers.InvalidateImportedProducts}
+	go importer.Run(context.Background(), config.ImportPollInterval)
 
-	http.HandleFunc("/products", middleware.LogRequest(handlers.GetProducts))
-	http.HandleFunc("/products/add", middleware.LogRequest(handlers.AddProduct))
-	http.HandleFunc("/products/", middleware.LogRequest(handlers.GetProductByID))
+	// Serve metrics on their own listener, away from the public API
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", metrics.Handler())
+	go func() {
+		utils.Logger.WithField("addr", config.MetricsAddr).Info("Metrics server is listening")
+		utils.Logger.Fatal(http.ListenAndServe(config.MetricsAddr, metricsMux))
+	}()
 
 	// Start server
 	utils.Logger.Info("Server is listening on port 8082")
-	utils.Logger.Fatal(http.ListenAndServe(":8082", nil))
+	// CORS answers preflight requests before the mux, whose routes only match their own methods
+	handler := middleware.SecurityHeaders(middleware.CORS(http.DefaultServeMux.ServeHTTP))
+	utils.Logger.Fatal(http.ListenAndServe(":8082", handler))
 }
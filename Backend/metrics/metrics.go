@@ -0,0 +1,77 @@
+// Package metrics defines the Prometheus metrics of the Backend, served on GET /metrics. The
+// database and Redis instrumentation also records the calls as trace spans.
+package metrics
+
+import (
+	"net/http"
+
+	"backend/cache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// HTTPRequests counts handled requests by route name, method and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes how long requests took to handle.
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// DBDuration observes database calls by operation: query, exec or commit.
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_call_duration_seconds",
+		Help:    "Time taken by PostgreSQL calls, by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	// RedisDuration observes Redis commands by name; pipelines are observed as "pipeline".
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Time taken by Redis commands, by command.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"command"})
+
+	// PublishFailures counts failed attempts to publish image jobs to RabbitMQ. A batch stops
+	// at its first failure and counts once.
+	PublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_job_publish_failures_total",
+		Help: "Failed attempts to publish image processing jobs to RabbitMQ.",
+	})
+)
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterCache exports the lookup counts of c under the given name. The hit ratio is
+// cache_hits_total / (cache_hits_total + cache_misses_total).
+func RegisterCache(name string, c *cache.Cache) {
+	labels := prometheus.Labels{"cache": name}
+	counters := []struct {
+		name, help string
+		value      func(cache.Stats) uint64
+	}{
+		{"cache_hits_total", "Cache lookups served from the cache.", func(s cache.Stats) uint64 { return s.Hits }},
+		{"cache_l1_hits_total", "Cache hits served from process memory.", func(s cache.Stats) uint64 { return s.L1Hits }},
+		{"cache_misses_total", "Cache lookups that had to load the value.", func(s cache.Stats) uint64 { return s.Misses }},
+		{"cache_errors_total", "Failed Redis commands the cache fell back from.", func(s cache.Stats) uint64 { return s.Errors }},
+	}
+	for _, counter := range counters {
+		value := counter.value
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        counter.name,
+			Help:        counter.help,
+			ConstLabels: labels,
+		}, func() float64 { return float64(value(c.Stats())) })
+	}
+}
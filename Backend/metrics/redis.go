@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"backend/tracing"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisHook observes the commands of the client it is added to in RedisDuration and records
+// them as client spans. Missing keys are not errors.
+type RedisHook struct{}
+
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		done := observeRedis(ctx, cmd.Name())
+		err := next(ctx, cmd)
+		done(err)
+		return err
+	}
+}
+
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		done := observeRedis(ctx, "pipeline", attribute.Int("db.redis.commands", len(cmds)))
+		err := next(ctx, cmds)
+		done(err)
+		return err
+	}
+}
+
+// observeRedis starts observing a command; the returned function ends it with its error.
+func observeRedis(ctx context.Context, command string, attrs ...attribute.KeyValue) func(error) {
+	start := time.Now()
+	attrs = append(attrs, semconv.DBSystemRedis, semconv.DBOperationName(command))
+	_, span := tracing.Tracer.Start(ctx, command, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return func(err error) {
+		RedisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		if err != nil && err != redis.Nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+var _ redis.Hook = RedisHook{}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"backend/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapConnector returns a connector whose connections observe their queries, execs and
+// commits in DBDuration and record them as client spans. Everything else is passed through to
+// the wrapped connections.
+func WrapConnector(c driver.Connector) driver.Connector {
+	return connector{c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{cn}, nil
+}
+
+// observeDB starts observing a call; the returned function ends it with the call's error.
+// Spans are named after the statement's first keyword, e.g. SELECT. Statements only carry
+// placeholders, so their text is recorded as is.
+func observeDB(ctx context.Context, operation, query string) func(error) {
+	start := time.Now()
+	name := strings.ToUpper(operation)
+	if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL}
+	if query != "" {
+		attrs = append(attrs, semconv.DBQueryText(query))
+	}
+	_, span := tracing.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return func(err error) {
+		DBDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// conn implements the optional interfaces database/sql looks for by delegating to the
+// wrapped connection, falling back the way database/sql would if it doesn't have them.
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := observeDB(ctx, "query", query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	done(err)
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := observeDB(ctx, "exec", query)
+	result, err := execer.ExecContext(ctx, query, args)
+	done(err)
+	return result, err
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transaction{Tx: tx, ctx: ctx}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type transaction struct {
+	driver.Tx
+	// ctx is the context the transaction was begun with, for the span of its commit.
+	ctx context.Context
+}
+
+func (tx transaction) Commit() error {
+	done := observeDB(tx.ctx, "commit", "")
+	err := tx.Tx.Commit()
+	done(err)
+	return err
+}
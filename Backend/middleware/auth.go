@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/auth"
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+)
+
+// Authenticate rejects requests without valid credentials and stores the authenticated user
+// in the request context. Callers authenticate with either an "Authorization: Bearer <jwt>"
+// header or an "X-API-Key" header.
+func Authenticate(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return authenticate(handlerFunc, true)
+}
+
+// OptionalAuthenticate behaves like Authenticate but lets anonymous requests through.
+// Requests that do present credentials must still present valid ones.
+func OptionalAuthenticate(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return authenticate(handlerFunc, false)
+}
+
+func authenticate(handlerFunc http.HandlerFunc, required bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok, err := credentials(r)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				unauthorized(w, r, "Invalid credentials")
+				return
+			}
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			if required {
+				unauthorized(w, r, "Authentication required")
+				return
+			}
+			handlerFunc(w, r)
+			return
+		}
+
+		handlerFunc(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	}
+}
+
+// credentials resolves the user behind the request's credentials. ok is false when the
+// request carries none.
+func credentials(r *http.Request) (user models.User, ok bool, err error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return models.User{}, false, auth.ErrInvalidCredentials
+		}
+		user, err = auth.ParseToken(config.JWTSecret, strings.TrimSpace(token))
+		if err != nil {
+			return models.User{}, false, err
+		}
+		user, err = auth.LookupUser(r.Context(), config.DB, user.UserID)
+		return user, err == nil, err
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if lookup, found := r.Context().Value(apiKeyLookupKey{}).(apiKeyLookup); found && lookup.key == key {
+			return lookup.user, lookup.err == nil, lookup.err
+		}
+		user, err = auth.LookupAPIKey(r.Context(), config.DB, key)
+		return user, err == nil, err
+	}
+
+	return models.User{}, false, nil
+}
+
+type apiKeyLookupKey struct{}
+
+// apiKeyLookup is the result of looking up an API key, kept so a request is only looked up once.
+type apiKeyLookup struct {
+	key  string
+	user models.User
+	err  error
+}
+
+// withAPIKeyLookup looks up key and stores the result in the context of the returned request.
+func withAPIKeyLookup(r *http.Request, key string) *http.Request {
+	user, err := auth.LookupAPIKey(r.Context(), config.DB, key)
+	return r.WithContext(context.WithValue(r.Context(), apiKeyLookupKey{}, apiKeyLookup{key: key, user: user, err: err}))
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="zocket"`)
+	http.Error(w, message, http.StatusUnauthorized)
+	utils.Log(r.Context()).WithFields(map[string]interface{}{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Warn(message)
+}
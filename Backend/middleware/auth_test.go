@@ -0,0 +1,164 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("test-secret-that-is-at-least-32-bytes")
+
+// echoUser responds with the authenticated user's ID, or 204 for anonymous requests
+func echoUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Write([]byte(user.Name))
+}
+
+func TestAuthenticate(t *testing.T) {
+	config.JWTSecret = testSecret
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	handler := middleware.Authenticate(echoUser)
+
+	t.Run("Bearer Token", func(t *testing.T) {
+		token, err := auth.IssueToken(testSecret, models.User{UserID: 3, Name: "alice"}, time.Minute)
+		assert.NoError(t, err)
+		mock.ExpectQuery("FROM users WHERE user_id = \\$1 AND deleted_at IS NULL").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "role"}).AddRow("alice", "user"))
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "alice", w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Token Of Deleted User", func(t *testing.T) {
+		token, err := auth.IssueToken(testSecret, models.User{UserID: 3, Name: "alice"}, time.Minute)
+		assert.NoError(t, err)
+		mock.ExpectQuery("FROM users WHERE user_id = \\$1 AND deleted_at IS NULL").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "role"}))
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Token Of Demoted Admin", func(t *testing.T) {
+		token, err := auth.IssueToken(testSecret, models.User{UserID: 3, Name: "alice", Role: models.RoleAdmin}, time.Minute)
+		assert.NoError(t, err)
+		mock.ExpectQuery("FROM users WHERE user_id = \\$1 AND deleted_at IS NULL").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "role"}).AddRow("alice", models.RoleUser))
+
+		var role string
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		middleware.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := auth.UserFromContext(r.Context())
+			role = user.Role
+		})(httptest.NewRecorder(), req)
+
+		assert.Equal(t, models.RoleUser, role)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Expired Token", func(t *testing.T) {
+		token, err := auth.IssueToken(testSecret, models.User{UserID: 3}, -time.Minute)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Token Signed With Another Secret", func(t *testing.T) {
+		token, err := auth.IssueToken([]byte("some-other-secret-of-sufficient-length"), models.User{UserID: 3}, time.Minute)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("API Key", func(t *testing.T) {
+		mock.ExpectQuery("FROM api_keys").
+			WithArgs(auth.HashAPIKey("zk_secret")).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "role"}).AddRow(5, "bob", "user"))
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-API-Key", "zk_secret")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "bob", w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown API Key", func(t *testing.T) {
+		mock.ExpectQuery("FROM api_keys").
+			WithArgs(auth.HashAPIKey("zk_revoked")).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "role"}))
+
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-API-Key", "zk_revoked")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing Credentials", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		handler(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Optional", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		middleware.OptionalAuthenticate(echoUser)(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
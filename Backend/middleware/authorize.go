@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/auth"
+	"backend/policy"
+	"backend/utils"
+)
+
+// Authorize checks the authenticated user against the policy for action before calling
+// handlerFunc. It must be wrapped by Authenticate.
+func Authorize(action policy.Action, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.UserFromContext(r.Context())
+		if !ok {
+			unauthorized(w, r, "Authentication required")
+			return
+		}
+
+		err := policy.Authorize(r, user, action)
+		switch {
+		case err == nil:
+			handlerFunc(w, r)
+		case errors.Is(err, policy.ErrNotFound):
+			http.Error(w, "Not found", http.StatusNotFound)
+		case errors.Is(err, policy.ErrForbidden):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			utils.Log(r.Context()).WithFields(map[string]interface{}{
+				"method":  r.Method,
+				"path":    r.URL.Path,
+				"user_id": user.UserID,
+				"action":  action,
+			}).Warn("Request forbidden by policy")
+		default:
+			utils.HandleError(w, r, err, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/auth"
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+	"backend/policy"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	request := func(user *models.User, id string) *http.Request {
+		req := httptest.NewRequest(http.MethodPatch, "/products/"+id, nil)
+		req.SetPathValue("id", id)
+		if user != nil {
+			req = req.WithContext(auth.WithUser(req.Context(), *user))
+		}
+		return req
+	}
+	owner := &models.User{UserID: 1, Role: models.RoleUser}
+	other := &models.User{UserID: 2, Role: models.RoleUser}
+	admin := &models.User{UserID: 3, Role: models.RoleAdmin}
+
+	cases := []struct {
+		name   string
+		user   *models.User
+		status int
+	}{
+		{"Owner", owner, http.StatusOK},
+		{"Other User", other, http.StatusForbidden},
+		{"Admin", admin, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock.ExpectQuery("SELECT user_id FROM products").WithArgs(10).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+
+			w := httptest.NewRecorder()
+			middleware.Authorize(policy.UpdateProduct, ok)(w, request(tc.user, "10"))
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Missing Product", func(t *testing.T) {
+		mock.ExpectQuery("SELECT user_id FROM products").WithArgs(11).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+		w := httptest.NewRecorder()
+		middleware.Authorize(policy.DeleteProduct, ok)(w, request(other, "11"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		middleware.Authorize(policy.UpdateProduct, ok)(w, request(nil, "10"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Unknown Action Is Admin Only", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		middleware.Authorize(policy.Action("products:frobnicate"), ok)(w, request(owner, "10"))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		middleware.Authorize(policy.Action("products:frobnicate"), ok)(w, request(admin, "10"))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
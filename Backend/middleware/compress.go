@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"backend/config"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encoder is the part of gzip.Writer and brotli.Writer that Compress uses.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Encoders are pooled as each one holds hundreds of kilobytes of compression state. Brotli's
+// default level is meant for static assets; 4 compresses responses better than gzip's default
+// at a similar speed.
+var encoders = map[string]*sync.Pool{
+	"br": {New: func() interface{} { return brotli.NewWriterLevel(nil, 4) }},
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+// Compress compresses responses of at least config.CompressMinSize bytes with brotli or gzip,
+// whichever the client prefers in Accept-Encoding, brotli on ties. Responses are buffered until
+// they reach that size, unless the handler flushes them first, which streamed responses like
+// exports do and which also starts compression. Responses that are already encoded or whose
+// content type doesn't compress, like images, are passed through as is. The strong ETag of a
+// compressed response gets the encoding appended, e.g. "7-gzip".
+func Compress(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			handlerFunc(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		handlerFunc(cw, r)
+	}
+}
+
+// negotiateEncoding returns "br" or "gzip", whichever header gives the higher quality, or ""
+// if it accepts neither.
+func negotiateEncoding(header string) string {
+	var brQ, gzipQ, anyQ float64 = -1, -1, -1
+	for _, entry := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "br":
+			brQ = q
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	// Codings not listed get the quality of *
+	if brQ < 0 {
+		brQ = anyQ
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+
+	switch {
+	case brQ > 0 && brQ >= gzipQ:
+		return "br"
+	case gzipQ > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter holds back the status and the start of the body until it knows whether the
+// response is large enough to compress.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	started bool
+	enc     encoder // nil unless the response is being compressed
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.started {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if status >= 100 && status < 200 {
+		// Informational responses, e.g. 103 Early Hints, go out right away
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < config.CompressMinSize {
+			return len(b), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// start sends the status and the buffered body, compressing them if compress is set and the
+// response is worth compressing.
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// net/http would sniff the compressed bytes otherwise
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && w.compressible() {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// Each encoding is a different representation, so it needs a different strong ETag
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) && len(etag) > 1 {
+			h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+		}
+		w.enc = encoders[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compressible reports whether the response has a body and a content type that compresses.
+func (w *compressWriter) compressible() bool {
+	if w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml", "application/javascript":
+		return true
+	}
+	return false
+}
+
+// Flush sends what has been written so far, starting compression if it hadn't started yet.
+func (w *compressWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError is what http.ResponseController calls to flush.
+func (w *compressWriter) FlushError() error {
+	if !w.started {
+		if err := w.start(true); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close sends whatever the handler left buffered and finishes the compressed stream.
+func (w *compressWriter) close() {
+	if !w.started {
+		if w.status == 0 && len(w.buf) == 0 {
+			// The handler wrote nothing; net/http answers 200 with an empty body
+			return
+		}
+		// Too small to compress
+		w.start(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		encoders[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
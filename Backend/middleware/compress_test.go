@@ -0,0 +1,129 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/middleware"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	large := `[` + strings.Repeat(`{"product_name": "Desk lamp", "product_price": "12.50"},`, 100) + `{}]`
+	respond := func(contentType, body string) http.HandlerFunc {
+		return middleware.Compress(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		})
+	}
+	decode := map[string]func(io.Reader) (io.Reader, error){
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{"Brotli Preferred", "gzip, deflate, br", "application/json", large, "br"},
+		{"Gzip By Quality", "br;q=0.5, gzip", "application/json", large, "gzip"},
+		{"Wildcard", "*", "application/json", large, "br"},
+		{"Refused", "br;q=0, gzip;q=0", "application/json", large, ""},
+		{"Not Accepted", "", "application/json", large, ""},
+		{"Too Small", "gzip", "application/json", `{"product_id": 1}`, ""},
+		{"Incompressible Type", "gzip", "image/jpeg", large, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			respond(tt.contentType, tt.body)(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			body := io.Reader(w.Body)
+			if tt.wantEncoding != "" {
+				assert.Less(t, w.Body.Len(), len(tt.body))
+				var err error
+				body, err = decode[tt.wantEncoding](w.Body)
+				require.NoError(t, err)
+			}
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(got))
+		})
+	}
+
+	t.Run("ETag Per Encoding", func(t *testing.T) {
+		for _, tt := range []struct{ acceptEncoding, body, want string }{
+			{"gzip", large, `"7-gzip"`},
+			{"br", large, `"7-br"`},
+			{"gzip", `{"product_id": 1}`, `"7"`},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			middleware.Compress(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"7"`)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			})(w, req)
+
+			assert.Equal(t, tt.want, w.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("Status Kept", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+
+		middleware.Compress(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+		})(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Product not found\n", w.Body.String())
+	})
+
+	t.Run("Flushed Stream", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/export", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+
+		var flushedBytes int
+		middleware.Compress(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("product_id,product_name\n1,Desk lamp\n"))
+			require.NoError(t, http.NewResponseController(w).Flush())
+			flushedBytes = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+			w.Write([]byte("2,Floor lamp\n"))
+		})(w, req)
+
+		assert.True(t, w.Flushed)
+		assert.Positive(t, flushedBytes)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		got, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, "product_id,product_name\n1,Desk lamp\n2,Floor lamp\n", string(got))
+	})
+}
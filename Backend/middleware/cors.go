@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"backend/config"
+)
+
+// Request and response headers browsers may use cross-origin, beyond the CORS-safelisted ones.
+const (
+	corsAllowedMethods = "GET, POST, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, If-Match, If-None-Match, X-API-Key, X-Request-ID"
+	corsExposedHeaders = "ETag, Retry-After, X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+)
+
+// CORS lets browsers call the API from config.CORSAllowedOrigins. It answers preflight
+// requests itself, so it has to wrap the whole mux: the routes only match their own methods.
+// Requests from other origins are passed on without CORS headers, which makes browsers refuse
+// their responses. Credentials are sent in headers, never cookies, so they aren't allowed.
+func CORS(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := corsAllowedOrigin(origin)
+		if allowed == "" {
+			handlerFunc(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allowed)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		handlerFunc(w, r)
+	}
+}
+
+// corsAllowedOrigin returns the Access-Control-Allow-Origin for origin, or "" if it isn't allowed.
+func corsAllowedOrigin(origin string) string {
+	if slices.Contains(config.CORSAllowedOrigins, "*") {
+		return "*"
+	}
+	if slices.ContainsFunc(config.CORSAllowedOrigins, func(allowed string) bool { return strings.EqualFold(allowed, origin) }) {
+		return origin
+	}
+	return ""
+}
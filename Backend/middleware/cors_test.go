@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	defer func(origins []string) { config.CORSAllowedOrigins = origins }(config.CORSAllowedOrigins)
+	config.CORSAllowedOrigins = []string{"https://shop.example.com"}
+	config.CORSMaxAge = 10 * time.Minute
+
+	var handled bool
+	handler := middleware.CORS(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+		w.Write([]byte("[]"))
+	})
+	request := func(method, origin string) *http.Request {
+		req := httptest.NewRequest(method, "/products", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req
+	}
+
+	t.Run("Allowed Origin", func(t *testing.T) {
+		handled = false
+		w := httptest.NewRecorder()
+		handler(w, request(http.MethodGet, "https://shop.example.com"))
+
+		assert.True(t, handled)
+		assert.Equal(t, "https://shop.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+		assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		handled = false
+		req := request(http.MethodOptions, "https://shop.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+		req.Header.Set("Access-Control-Request-Headers", "authorization, if-match")
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		assert.False(t, handled)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://shop.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PATCH")
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "If-Match")
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("Other Origin", func(t *testing.T) {
+		handled = false
+		w := httptest.NewRecorder()
+		handler(w, request(http.MethodGet, "https://evil.example.com"))
+
+		assert.True(t, handled)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+	})
+
+	t.Run("Same Origin", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler(w, request(http.MethodGet, ""))
+
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("Any Origin", func(t *testing.T) {
+		config.CORSAllowedOrigins = []string{"*"}
+		w := httptest.NewRecorder()
+		handler(w, request(http.MethodGet, "https://evil.example.com"))
+
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -6,16 +6,22 @@ import (
 	"backend/utils"
 )
 
+// LogRequest logs every request once it has been handled, with its status code, response
+// size, duration and client IP, through the request's logger.
 func LogRequest(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
-		handlerFunc(w, r)
+		rec := recordResponse(w)
+		handlerFunc(rec, r)
 		duration := time.Since(startTime)
 
-		utils.Logger.WithFields(map[string]interface{}{
+		utils.Log(r.Context()).WithFields(map[string]interface{}{
 			"method":     r.Method,
 			"path":       r.URL.Path,
+			"status":     rec.Status(),
+			"bytes":      rec.bytes,
 			"duration":   duration.String(),
+			"client_ip":  clientIP(r),
 			"user_agent": r.UserAgent(),
 		}).Info("Request handled")
 	}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/metrics"
+)
+
+// Measure counts the requests to route and observes their duration by method and status. It
+// should be the outermost middleware, so that requests rejected by the others are counted too.
+func Measure(route string, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := recordResponse(w)
+		handlerFunc(rec, r)
+
+		status := strconv.Itoa(rec.Status())
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
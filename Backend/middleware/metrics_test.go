@@ -0,0 +1,31 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/metrics"
+	"backend/middleware"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeasure(t *testing.T) {
+	handler := middleware.Measure("test:measure", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("missing") != "" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	for _, target := range []string{"/", "/", "/?missing=1"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("test:measure", "GET", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("test:measure", "GET", "404")))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.HTTPDuration.MustCurryWith(map[string]string{"route": "test:measure"})))
+}
@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+	"backend/ratelimit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	config.RateLimiter = ratelimit.NewMemoryLimiter()
+	config.RateLimits = ratelimit.Config{
+		Default: ratelimit.Limit{Rate: 100, Burst: 100},
+		Routes:  map[string]ratelimit.Limit{"products:create": {Rate: 0.5, Burst: 2}},
+	}
+
+	handler := middleware.RateLimit("products:create", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/products/add", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := request("10.0.0.1:1234")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusCreated, request("10.0.0.1:1234").Code)
+
+	w = request("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	// Another client is unaffected
+	assert.Equal(t, http.StatusCreated, request("10.0.0.2:1234").Code)
+}
+
+func TestRateLimitChargesFailedAuthentication(t *testing.T) {
+	config.JWTSecret = testSecret
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+	config.RateLimiter = ratelimit.NewMemoryLimiter()
+	config.RateLimits = ratelimit.Config{Default: ratelimit.Limit{Rate: 0.5, Burst: 2}}
+
+	handler := middleware.RateLimit("products:update", middleware.Authenticate(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/products/1", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	// Guesses are charged to the IP, whatever token they try
+	assert.Equal(t, http.StatusUnauthorized, request("Bearer guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, request("Bearer guess-2"))
+	assert.Equal(t, http.StatusTooManyRequests, request("Bearer guess-3"))
+
+	// Callers with a valid token have their own bucket
+	token, err := auth.IssueToken(config.JWTSecret, models.User{UserID: 7, Role: models.RoleUser}, time.Hour)
+	assert.NoError(t, err)
+	mock.ExpectQuery("FROM users").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"name", "role"}).AddRow("carol", "user"))
+	assert.Equal(t, http.StatusOK, request("Bearer "+token))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRateLimitPerAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	config.DB = db
+	config.RateLimiter = ratelimit.NewMemoryLimiter()
+	config.RateLimits = ratelimit.Config{Default: ratelimit.Limit{Rate: 0.5, Burst: 1}}
+
+	handler := middleware.RateLimit("products:update", middleware.Authenticate(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(key string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/products/1", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+	expectKey := func(key string, userID int) {
+		mock.ExpectQuery("FROM api_keys").WithArgs(auth.HashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "role"}).AddRow(userID, "bob", "user"))
+	}
+
+	// Each key is looked up once and has its own bucket
+	expectKey("zk_first", 5)
+	assert.Equal(t, http.StatusOK, request("zk_first"))
+	expectKey("zk_second", 6)
+	assert.Equal(t, http.StatusOK, request("zk_second"))
+	expectKey("zk_first", 5)
+	assert.Equal(t, http.StatusTooManyRequests, request("zk_first"))
+
+	// Unknown keys are charged to the IP
+	mock.ExpectQuery("FROM api_keys").WithArgs(auth.HashAPIKey("zk_guess-1")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "role"}))
+	assert.Equal(t, http.StatusUnauthorized, request("zk_guess-1"))
+	mock.ExpectQuery("FROM api_keys").WithArgs(auth.HashAPIKey("zk_guess-2")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "role"}))
+	assert.Equal(t, http.StatusTooManyRequests, request("zk_guess-2"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Recover turns a panic in the handler into a 500 with a JSON body carrying the request ID,
+// and logs it with its stack, instead of letting net/http drop the connection. If the handler
+// had already started its response, the connection is aborted so that the client doesn't take
+// the truncated body for a complete one. It should be wrapped by LogRequest and Measure so
+// the 500 is logged and counted.
+func Recover(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := recordResponse(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// The handler meant to abort the connection
+				panic(p)
+			}
+
+			utils.Log(r.Context()).WithFields(logrus.Fields{
+				"panic": fmt.Sprint(p),
+				"stack": string(debug.Stack()),
+			}).Error("Handler panicked")
+
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			utils.SendJSONResponse(rec, map[string]string{
+				"error":      "Internal server error",
+				"request_id": utils.RequestID(r.Context()),
+			}, http.StatusInternalServerError)
+		}()
+
+		handlerFunc(rec, r)
+	}
+}
@@ -0,0 +1,53 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/middleware"
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	hook := test.NewLocal(utils.Logger)
+	defer hook.Reset()
+
+	t.Run("Before Response", func(t *testing.T) {
+		hook.Reset()
+		handler := middleware.RequestID(middleware.LogRequest(middleware.Recover(func(w http.ResponseWriter, r *http.Request) {
+			var ids []int
+			_ = ids[len(r.URL.Path)]
+		})))
+		req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+		req.Header.Set("X-Request-ID", "edge-42")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error": "Internal server error", "request_id": "edge-42"}`, w.Body.String())
+		if assert.Len(t, hook.AllEntries(), 2) {
+			panicked := hook.AllEntries()[0]
+			assert.Equal(t, logrus.ErrorLevel, panicked.Level)
+			assert.Contains(t, panicked.Data["panic"], "index out of range")
+			assert.Contains(t, panicked.Data["stack"], "recover_test.go")
+			assert.Equal(t, http.StatusInternalServerError, hook.LastEntry().Data["status"])
+		}
+	})
+
+	t.Run("After Response Started", func(t *testing.T) {
+		handler := middleware.Recover(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"product_id": 1},`))
+			panic("lost the rows")
+		})
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/products", nil))
+		})
+	})
+}
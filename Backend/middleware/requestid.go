@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"backend/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDPattern matches the request IDs accepted from clients and proxies; others are
+// replaced, so that they can't inject anything into logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID identifies each request by its X-Request-ID header, or by a new random ID if it
+// has none or an invalid one, and echoes the ID in the response. The request's context
+// carries the ID and a logger with the request's ID, method, path and trace ID for handlers
+// to log through, so it should wrap everything but Trace.
+func RequestID(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		fields := logrus.Fields{
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+		}
+		if span := trace.SpanFromContext(r.Context()); span.SpanContext().IsValid() {
+			span.SetAttributes(attribute.String("http.request.id", id))
+			fields["trace_id"] = span.SpanContext().TraceID().String()
+		}
+
+		ctx := utils.WithRequestID(r.Context(), id)
+		ctx = utils.WithLogger(ctx, utils.Logger.WithFields(fields))
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
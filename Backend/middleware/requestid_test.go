@@ -0,0 +1,56 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/middleware"
+	"backend/utils"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	hook := test.NewLocal(utils.Logger)
+	defer hook.Reset()
+
+	var handlerID string
+	handler := middleware.RequestID(middleware.LogRequest(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = utils.RequestID(r.Context())
+		utils.Log(r.Context()).Info("Handled")
+		http.Error(w, "Not found", http.StatusNotFound)
+	}))
+
+	t.Run("Propagated", func(t *testing.T) {
+		hook.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+		req.Header.Set("X-Request-ID", "edge-42")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Equal(t, "edge-42", w.Header().Get("X-Request-ID"))
+		assert.Equal(t, "edge-42", handlerID)
+		if assert.Len(t, hook.AllEntries(), 2) {
+			assert.Equal(t, "edge-42", hook.AllEntries()[0].Data["request_id"])
+			logged := hook.LastEntry().Data
+			assert.Equal(t, "edge-42", logged["request_id"])
+			assert.Equal(t, http.StatusNotFound, logged["status"])
+			assert.Equal(t, len("Not found\n"), logged["bytes"])
+			assert.Equal(t, "192.0.2.1", logged["client_ip"])
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+		req.Header.Set("X-Request-ID", "bad id\n")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		assert.Regexp(t, `^[0-9a-f]{32}$`, w.Header().Get("X-Request-ID"))
+		assert.Equal(t, w.Header().Get("X-Request-ID"), handlerID)
+	})
+}
@@ -0,0 +1,30 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	defer func(maxAge time.Duration) { config.HSTSMaxAge = maxAge }(config.HSTSMaxAge)
+	handler := middleware.SecurityHeaders(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	config.HSTSMaxAge = 365 * 24 * time.Hour
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"backend/config"
+)
+
+// Timeout cancels the request's context once the timeout configured for route has passed,
+// which aborts the database and Redis calls made with it; utils.HandleError then answers 503.
+// The handler itself keeps running until it notices, so streamed responses can still end
+// cleanly. Routes with a zero timeout are not limited.
+func Timeout(route string, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := config.RouteTimeouts[route]
+		if !ok {
+			timeout = config.RequestTimeout
+		}
+		if timeout <= 0 {
+			handlerFunc(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,55 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	defer func(timeout time.Duration, routes map[string]time.Duration) {
+		config.RequestTimeout, config.RouteTimeouts = timeout, routes
+	}(config.RequestTimeout, config.RouteTimeouts)
+	config.RequestTimeout = 10 * time.Millisecond
+	config.RouteTimeouts = map[string]time.Duration{"test:slow": time.Second, "test:unlimited": 0}
+
+	// slow stands in for a handler whose database call is cancelled with the request
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			utils.HandleError(w, r, r.Context().Err(), http.StatusInternalServerError)
+		case <-time.After(50 * time.Millisecond):
+			w.Write([]byte("ok"))
+		}
+	}
+
+	tests := []struct {
+		route    string
+		wantCode int
+	}{
+		{"test:default", http.StatusServiceUnavailable},
+		{"test:slow", http.StatusOK},
+		{"test:unlimited", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			middleware.Timeout(tt.route, slow)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+
+	t.Run("Unlimited Has No Deadline", func(t *testing.T) {
+		middleware.Timeout("test:unlimited", func(w http.ResponseWriter, r *http.Request) {
+			_, ok := r.Context().Deadline()
+			assert.False(t, ok)
+		})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
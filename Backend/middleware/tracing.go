@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+
+	"backend/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace handles each request to route in a server span, continuing the caller's trace if the
+// request carries a traceparent header. Spans are named after the method and route, and mark
+// server errors as failed. It should be the outermost middleware, so that the others are
+// included in the span.
+func Trace(route string, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(r.Pattern),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+
+		rec := recordResponse(w)
+		handlerFunc(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+// Category is a node in the product category tree. Top-level categories have no parent.
+type Category struct {
+	ID       int    `json:"category_id"`
+	Name     string `json:"name" validate:"notblank,max=100"`
+	ParentID *int   `json:"parent_id" validate:"omitnil,gt=0"`
+}
+
+// CategoryUpdate is a partial update of a category. Nil fields are left unchanged; a ParentID
+// of 0 moves the category to the top level.
+type CategoryUpdate struct {
+	Name     *string `json:"name" validate:"omitnil,notblank,max=100"`
+	ParentID *int    `json:"parent_id" validate:"omitnil,gte=0"`
+}
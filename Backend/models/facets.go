@@ -0,0 +1,32 @@
+package models
+
+// ProductFacets summarizes the products matching a filter.
+type ProductFacets struct {
+	Total  int           `json:"total"`
+	Users  []UserFacet   `json:"users"`
+	Prices []PriceFacets `json:"prices"`
+}
+
+// UserFacet is the number of matching products owned by a user.
+type UserFacet struct {
+	UserID int `json:"user_id"`
+	Count  int `json:"count"`
+}
+
+// PriceFacets describes the prices of matching products in one currency. Prices in different
+// currencies are never mixed.
+type PriceFacets struct {
+	Currency string        `json:"currency"`
+	Count    int           `json:"count"`
+	MinPrice Price         `json:"min_price"`
+	MaxPrice Price         `json:"max_price"`
+	Buckets  []PriceBucket `json:"buckets"`
+}
+
+// PriceBucket counts the products priced from Min up to Max. Buckets are equally wide and the
+// last one includes its upper bound.
+type PriceBucket struct {
+	Min   Price `json:"min"`
+	Max   Price `json:"max"`
+	Count int   `json:"count"`
+}
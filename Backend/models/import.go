@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Formats accepted by the product import.
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+// Import job statuses. Jobs are pending until a worker claims them and end up completed or
+// failed; rows imported before a failure stay imported.
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ImportJob is a bulk product import and its progress. Rows that fail validation are
+// counted in FailedRows and listed in the job's error report; the rest are imported.
+type ImportJob struct {
+	ID            int        `json:"job_id"`
+	UserID        int        `json:"user_id"`
+	Format        string     `json:"format"`
+	Status        string     `json:"status"`
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	ImportedRows  int        `json:"imported_rows"`
+	FailedRows    int        `json:"failed_rows"`
+	Error         *string    `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
+
+// ImportError explains why a row of an import was rejected. Row is the 1-based position of
+// the row in the upload, not counting the CSV header or blank lines. Errors that concern the
+// whole row, such as malformed CSV or JSON, have no Field.
+type ImportError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
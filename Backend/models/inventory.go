@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// Kinds of stock movement recorded in the ledger.
+const (
+	MovementAdjust  = "adjust"
+	MovementReserve = "reserve"
+	MovementRelease = "release"
+)
+
+// Inventory is the stock level of a product, or of one of its variants when VariantID is set.
+// Version changes with every movement and is used for optimistic concurrency.
+type Inventory struct {
+	ProductID int  `json:"product_id"`
+	VariantID *int `json:"variant_id,omitempty"`
+	Stock     int  `json:"stock"`
+	Reserved  int  `json:"reserved"`
+	Available int  `json:"available"`
+	Version   int  `json:"version"`
+}
+
+// StockAdjustment adds to or removes from the stock on hand.
+type StockAdjustment struct {
+	Quantity int    `json:"quantity" validate:"ne=0,gte=-1000000,lte=1000000"`
+	Reason   string `json:"reason" validate:"max=255"`
+	// ExpectedVersion makes the adjustment fail with a conflict if the inventory has changed
+	// since the caller read it.
+	ExpectedVersion *int `json:"expected_version"`
+}
+
+// StockReservation holds back available stock, for example while a checkout completes.
+type StockReservation struct {
+	Quantity        int    `json:"quantity" validate:"gt=0,lte=1000000"`
+	Reason          string `json:"reason" validate:"max=255"`
+	ExpectedVersion *int   `json:"expected_version"`
+}
+
+// StockRelease returns the stock held by a reservation.
+type StockRelease struct {
+	ReservationID int64  `json:"reservation_id" validate:"required,gt=0"`
+	Reason        string `json:"reason" validate:"max=255"`
+}
+
+// StockMovement is an entry in the stock ledger. Quantity is the change to the stock on hand
+// for adjustments and the quantity held or returned for reservations and releases. Releases
+// refer to the reserve movement they undo through ReservationID.
+type StockMovement struct {
+	ID            int64     `json:"movement_id"`
+	ProductID     int       `json:"product_id"`
+	VariantID     *int      `json:"variant_id,omitempty"`
+	Kind          string    `json:"kind"`
+	Quantity      int       `json:"quantity"`
+	ReservationID *int64    `json:"reservation_id,omitempty"`
+	StockAfter    int       `json:"stock_after"`
+	ReservedAfter int       `json:"reserved_after"`
+	Reason        string    `json:"reason,omitempty"`
+	UserID        *int      `json:"user_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PriceScale is the number of decimal places stored for prices, matching DECIMAL(10,2).
+const PriceScale = 2
+
+// MaxPrice is the largest amount that fits in a DECIMAL(10,2) column, in minor units.
+const MaxPrice Price = 99999999_99
+
+// Price is an exact monetary amount in minor units (e.g. cents). It is encoded in JSON as
+// a decimal number with two fractional digits and stored in Postgres as a DECIMAL.
+type Price int64
+
+// ParsePrice parses a decimal string such as "19", "19.9" or "19.99" without going through
+// float64. More than PriceScale fractional digits is an error rather than being rounded.
+func ParsePrice(input string) (Price, error) {
+	s := strings.TrimSpace(input)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid price %q", input)
+	}
+	if len(frac) > PriceScale {
+		// Trailing zeros beyond the scale don't change the value (Postgres may return "1.500")
+		trimmed := strings.TrimRight(frac[PriceScale:], "0")
+		if trimmed != "" {
+			return 0, fmt.Errorf("price %q has more than %d decimal places", input, PriceScale)
+		}
+		frac = frac[:PriceScale]
+	}
+	frac += strings.Repeat("0", PriceScale-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	digits := whole + frac
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid price %q", input)
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price %q: %v", input, err)
+	}
+	if neg {
+		n = -n
+	}
+	return Price(n), nil
+}
+
+// String formats the price as a decimal, e.g. 1999 becomes "19.99".
+func (p Price) String() string {
+	n := int64(p)
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, n/100, n%100)
+}
+
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and strings, parsing the literal text exactly.
+func (p *Price) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParsePrice(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner for DECIMAL columns, which lib/pq returns as text.
+func (p *Price) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*p = Price(v * 100)
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', PriceScale, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into Price", src)
+	}
+	parsed, err := ParsePrice(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Value implements driver.Valuer, passing the price to Postgres as an exact decimal string.
+func (p Price) Value() (driver.Value, error) {
+	return p.String(), nil
+}
@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrice(t *testing.T) {
+	cases := map[string]models.Price{
+		"0":           0,
+		"19":          1900,
+		"19.9":        1990,
+		"19.99":       1999,
+		".5":          50,
+		"0.10":        10,
+		"1.500":       150,
+		"-3.25":       -325,
+		"99999999.99": models.MaxPrice,
+	}
+	for input, want := range cases {
+		got, err := models.ParsePrice(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", ".", "abc", "1.999", "1e3", "1.2.3"} {
+		_, err := models.ParsePrice(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestPriceJSON(t *testing.T) {
+	var p struct {
+		Price models.Price `json:"price"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"price": 0.1}`), &p))
+	assert.Equal(t, models.Price(10), p.Price)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"price": "1234.56"}`), &p))
+	assert.Equal(t, models.Price(123456), p.Price)
+
+	out, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"price": 1234.56}`, string(out))
+}
+
+func TestPriceScan(t *testing.T) {
+	var p models.Price
+	assert.NoError(t, p.Scan([]byte("10.10")))
+	assert.Equal(t, models.Price(1010), p)
+
+	// float64 sources are rounded to the column scale rather than truncated
+	assert.NoError(t, p.Scan(0.1+0.2))
+	assert.Equal(t, models.Price(30), p)
+}
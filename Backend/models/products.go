@@ -1,11 +1,134 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is assigned to products created without an explicit currency.
+const DefaultCurrency = "USD"
+
 type Product struct {
 	ID                      int      `json:"product_id"`
-	UserID                  int      `json:"user_id"`
-	ProductName             string   `json:"product_name"`
-	ProductDescription      string   `json:"product_description"`
-	ProductImages           []string `json:"product_images"`
+	UserID                  int      `json:"user_id" validate:"required,gt=0"`
+	ProductName             string   `json:"product_name" validate:"notblank,max=255"`
+	ProductDescription      string   `json:"product_description" validate:"max=5000"`
+	ProductImages           []string `json:"product_images" validate:"max=10,dive,http_url"`
 	CompressedProductImages []string `json:"compressed_product_images"`
-	ProductPrice            float64  `json:"product_price"`
+	ProductPrice            Price    `json:"product_price" validate:"gt=0,lte=9999999999"`
+	Currency                string   `json:"currency" validate:"iso4217"`
+	CategoryIDs             []int    `json:"category_ids" validate:"max=20,dive,gt=0"`
+	Tags                    []string `json:"tags" validate:"max=20,dive,notblank,max=50"`
+	// Stock is the quantity on hand, of which Reserved is held back. Products are created
+	// with an initial stock; afterwards it only changes through the inventory endpoints.
+	Stock    int `json:"stock" validate:"gte=0,lte=1000000"`
+	Reserved int `json:"reserved" validate:"-"`
+	// Version increases with every change to the product or its variants. Stock movements
+	// don't change it; they increase StockVersion, the version of the inventory, instead.
+	// Both are part of the product's ETag.
+	Version      int `json:"version" validate:"-"`
+	StockVersion int `json:"stock_version" validate:"-"`
+	// Variants are managed through their own endpoints and ignored when creating a product.
+	Variants []Variant `json:"variants" validate:"-"`
+	// DeletedAt is set when the product has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Search describes how the product matched a full-text search, if it was found by one.
+	Search *SearchMatch `json:"search,omitempty"`
+}
+
+// Normalize canonicalizes a new product before validation: the currency is uppercased and
+// defaulted, and repeated categories and tags are dropped.
+func (p *Product) Normalize() {
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	p.CategoryIDs = DedupeIDs(p.CategoryIDs)
+	p.Tags = NormalizeTags(p.Tags)
+}
+
+// NormalizeTags lowercases and trims tags and drops duplicates, keeping the first occurrence.
+// Blank tags are kept so validation can reject them.
+func NormalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// DedupeIDs drops repeated IDs, keeping the first occurrence.
+func DedupeIDs(ids []int) []int {
+	if ids == nil {
+		return nil
+	}
+	seen := make(map[int]bool, len(ids))
+	deduped := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			deduped = append(deduped, id)
+		}
+	}
+	return deduped
+}
+
+// SearchMatch is the relevance and highlighted snippets of a full-text search result. The
+// snippets are HTML-escaped with matching words wrapped in <mark> tags.
+type SearchMatch struct {
+	Rank               float64 `json:"rank"`
+	ProductName        string  `json:"product_name"`
+	ProductDescription string  `json:"product_description"`
+}
+
+// NewProduct is the body of a request to create a product. It only has the fields a client
+// may set, so a body carrying server-owned fields such as version or reserved is rejected
+// instead of having them silently ignored.
+type NewProduct struct {
+	UserID             int      `json:"user_id"`
+	ProductName        string   `json:"product_name"`
+	ProductDescription string   `json:"product_description"`
+	ProductImages      []string `json:"product_images"`
+	ProductPrice       Price    `json:"product_price"`
+	Currency           string   `json:"currency"`
+	CategoryIDs        []int    `json:"category_ids"`
+	Tags               []string `json:"tags"`
+	Stock              int      `json:"stock"`
+}
+
+// Product returns the product to create, normalized but not yet validated.
+func (n NewProduct) Product() Product {
+	p := Product{
+		UserID:             n.UserID,
+		ProductName:        n.ProductName,
+		ProductDescription: n.ProductDescription,
+		ProductImages:      n.ProductImages,
+		ProductPrice:       n.ProductPrice,
+		Currency:           n.Currency,
+		CategoryIDs:        n.CategoryIDs,
+		Tags:               n.Tags,
+		Stock:              n.Stock,
+	}
+	p.Normalize()
+	return p
+}
+
+// ProductUpdate is a partial update of a product. Nil fields are left unchanged.
+type ProductUpdate struct {
+	ProductName        *string   `json:"product_name" validate:"omitnil,notblank,max=255"`
+	ProductDescription *string   `json:"product_description" validate:"omitnil,max=5000"`
+	ProductImages      *[]string `json:"product_images" validate:"omitnil,max=10,dive,http_url"`
+	ProductPrice       *Price    `json:"product_price" validate:"omitnil,gt=0,lte=9999999999"`
+	Currency           *string   `json:"currency" validate:"omitnil,iso4217"`
+	CategoryIDs        *[]int    `json:"category_ids" validate:"omitnil,max=20,dive,gt=0"`
+	Tags               *[]string `json:"tags" validate:"omitnil,max=20,dive,notblank,max=50"`
 }
@@ -1,6 +1,27 @@
 package models
 
+import "time"
+
+// Roles a user can hold. Admins may manage every user's resources.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	UserID int    `json:"user_id"`
-	Name   string `json:"name"`
+	Name   string `json:"name" validate:"notblank,max=255"`
+	Role   string `json:"role"`
+	// DeletedAt is set when the user has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// UserUpdate is a partial update of a user. Nil fields are left unchanged.
+type UserUpdate struct {
+	Name *string `json:"name" validate:"omitnil,notblank,max=255"`
+	Role *string `json:"role" validate:"omitnil,oneof=user admin"`
 }
@@ -0,0 +1,54 @@
+package models
+
+import "strings"
+
+// Variant is a purchasable version of a product, such as a size or colour, identified by a
+// unique SKU. Its images are a subset of the product's images.
+type Variant struct {
+	ID         int               `json:"variant_id"`
+	ProductID  int               `json:"product_id"`
+	SKU        string            `json:"sku" validate:"notblank,max=64"`
+	Attributes map[string]string `json:"attributes" validate:"max=20,dive,keys,notblank,max=50,endkeys,max=255"`
+	// PriceOverride replaces the product's price for this variant when set.
+	PriceOverride    *Price   `json:"price_override" validate:"omitnil,gt=0,lte=9999999999"`
+	Images           []string `json:"images" validate:"max=10,dive,http_url"`
+	CompressedImages []string `json:"compressed_images"`
+	// Stock, Reserved and StockVersion work as they do for products.
+	Stock        int `json:"stock" validate:"gte=0,lte=1000000"`
+	Reserved     int `json:"reserved" validate:"-"`
+	StockVersion int `json:"stock_version" validate:"-"`
+}
+
+// NewVariant is the body of a request to create a variant. Like NewProduct, it only has the
+// fields a client may set.
+type NewVariant struct {
+	SKU           string            `json:"sku"`
+	Attributes    map[string]string `json:"attributes"`
+	PriceOverride *Price            `json:"price_override"`
+	Images        []string          `json:"images"`
+	Stock         int               `json:"stock"`
+}
+
+// Variant returns the variant to create, normalized but not yet validated.
+func (n NewVariant) Variant() Variant {
+	v := Variant{
+		SKU:           strings.TrimSpace(n.SKU),
+		Attributes:    n.Attributes,
+		PriceOverride: n.PriceOverride,
+		Images:        n.Images,
+		Stock:         n.Stock,
+	}
+	if v.Attributes == nil {
+		v.Attributes = map[string]string{}
+	}
+	return v
+}
+
+// VariantUpdate is a partial update of a variant. Nil fields are left unchanged; a
+// PriceOverride of 0 removes the override. Stock is changed through the inventory endpoints.
+type VariantUpdate struct {
+	SKU           *string            `json:"sku" validate:"omitnil,notblank,max=64"`
+	Attributes    *map[string]string `json:"attributes" validate:"omitnil,max=20,dive,keys,notblank,max=50,endkeys,max=255"`
+	PriceOverride *Price             `json:"price_override" validate:"omitnil,gte=0,lte=9999999999"`
+	Images        *[]string          `json:"images" validate:"omitnil,max=10,dive,http_url"`
+}
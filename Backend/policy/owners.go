@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"backend/config"
+)
+
+// ErrNotFound is returned when the resource being authorized does not exist.
+var ErrNotFound = errors.New("not found")
+
+// OwnerFunc resolves the user_id owning the resource addressed by a request.
+type OwnerFunc func(r *http.Request) (int, error)
+
+// productOwner resolves the owner of the product in the {id} path segment.
+func productOwner(r *http.Request) (int, error) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, ErrNotFound
+	}
+
+	var ownerID int
+	err = config.DB.QueryRowContext(r.Context(), "SELECT user_id FROM products WHERE product_id = $1", productID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to look up product owner: %v", err)
+	}
+	return ownerID, nil
+}
+
+// importOwner resolves the user who started the import job in the {id} path segment.
+func importOwner(r *http.Request) (int, error) {
+	jobID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, ErrNotFound
+	}
+
+	var ownerID int
+	err = config.DB.QueryRowContext(r.Context(), "SELECT created_by FROM import_jobs WHERE job_id = $1", jobID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to look up import job owner: %v", err)
+	}
+	return ownerID, nil
+}
+
+// userOwner treats a user as owning their own record, addressed by the {id} path segment.
+func userOwner(r *http.Request) (int, error) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, ErrNotFound
+	}
+	return userID, nil
+}
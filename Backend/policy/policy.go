@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/models"
+)
+
+// Action names an operation that is subject to authorization.
+type Action string
+
+const (
+	ListUsers      Action = "users:list"
+	IssueToken     Action = "auth:token"
+	ReadUser       Action = "users:read"
+	UpdateUser     Action = "users:update"
+	DeleteUser     Action = "users:delete"
+	ChangeUserRole Action = "users:change_role"
+	RestoreUser    Action = "users:restore"
+	ViewDeleted    Action = "deleted:view"
+	CreateProduct  Action = "products:create"
+	UpdateProduct  Action = "products:update"
+	DeleteProduct  Action = "products:delete"
+	RestoreProduct Action = "products:restore"
+	CreateCategory Action = "categories:create"
+	UpdateCategory Action = "categories:update"
+	DeleteCategory Action = "categories:delete"
+	CreateVariant  Action = "variants:create"
+	UpdateVariant  Action = "variants:update"
+	DeleteVariant  Action = "variants:delete"
+	AdjustStock    Action = "inventory:adjust"
+	ReserveStock   Action = "inventory:reserve"
+	ReleaseStock   Action = "inventory:release"
+	ViewLedger     Action = "inventory:ledger"
+	ImportProducts Action = "products:import"
+	ViewImport     Action = "imports:view"
+	ViewCacheStats Action = "cache:stats"
+)
+
+// ErrForbidden is returned when a user may not perform an action.
+var ErrForbidden = errors.New("forbidden")
+
+// Rule describes who may perform an action. Admins may perform every action.
+type Rule struct {
+	// AdminOnly restricts the action to admins.
+	AdminOnly bool
+	// Owner resolves the owner of the target resource. When set, only that owner may
+	// perform the action.
+	Owner OwnerFunc
+}
+
+// rules is the single source of truth for authorization. Actions that are not listed here
+// are denied to everyone but admins, so a handler registered with a new action is locked
+// down until a rule is added for it.
+var rules = map[Action]Rule{
+	ListUsers:      {},
+	IssueToken:     {},
+	ReadUser:       {Owner: userOwner},
+	UpdateUser:     {Owner: userOwner},
+	DeleteUser:     {Owner: userOwner},
+	ChangeUserRole: {AdminOnly: true},
+	RestoreUser:    {AdminOnly: true},
+	ViewDeleted:    {AdminOnly: true},
+	CreateProduct:  {},
+	UpdateProduct:  {Owner: productOwner},
+	DeleteProduct:  {Owner: productOwner},
+	RestoreProduct: {Owner: productOwner},
+	CreateCategory: {AdminOnly: true},
+	UpdateCategory: {AdminOnly: true},
+	DeleteCategory: {AdminOnly: true},
+	CreateVariant:  {Owner: productOwner},
+	UpdateVariant:  {Owner: productOwner},
+	DeleteVariant:  {Owner: productOwner},
+	AdjustStock:    {Owner: productOwner},
+	ReserveStock:   {},
+	ReleaseStock:   {},
+	ViewLedger:     {Owner: productOwner},
+	ImportProducts: {},
+	ViewImport:     {Owner: importOwner},
+	ViewCacheStats: {AdminOnly: true},
+}
+
+// Authorize reports whether user may perform action on the resource addressed by r. It
+// returns ErrNotFound if an owner-scoped resource does not exist, so callers can answer 404
+// before revealing anything else about it.
+func Authorize(r *http.Request, user models.User, action Action) error {
+	rule, ok := rules[action]
+	if ok && rule.Owner != nil {
+		ownerID, err := rule.Owner(r)
+		if err != nil {
+			return err
+		}
+		if user.IsAdmin() || ownerID == user.UserID {
+			return nil
+		}
+		return ErrForbidden
+	}
+
+	if user.IsAdmin() {
+		return nil
+	}
+	if !ok || rule.AdminOnly {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/metrics"
+	"backend/tracing"
+	"backend/utils"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ImageProcessingQueue is the queue consumed by the image processing microservice.
+const ImageProcessingQueue = "image_processing"
+
+// pingTimeout bounds the dial of Ping when its context has no deadline.
+const pingTimeout = 5 * time.Second
+
+// ImageJob is the message published for every product image that needs compressing. Jobs
+// for a product's images also fill in the compressed images of its variants using the same
+// image; jobs with a VariantID only update that variant.
+type ImageJob struct {
+	ProductID int    `json:"product_id"`
+	VariantID int    `json:"variant_id,omitempty"`
+	ImageURL  string `json:"image_url"`
+	// RequestID is the ID of the request that queued the job, so that the microservice's logs
+	// can be tied to it. Publishers fill it in from the context.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Publisher publishes image jobs for asynchronous processing.
+type Publisher interface {
+	Publish(ctx context.Context, job ImageJob) error
+	// PublishBatch publishes several jobs at once, stopping at the first failure.
+	PublishBatch(ctx context.Context, jobs []ImageJob) error
+}
+
+// RabbitMQPublisher publishes image jobs to a durable RabbitMQ queue. The connection is
+// dialed on first use and re-dialed if the broker drops it.
+type RabbitMQPublisher struct {
+	url   string
+	queue string
+
+	mu   sync.Mutex
+	conn *amqp091.Connection
+	ch   *amqp091.Channel
+	// live is conn, readable without waiting for p.mu while a publish or dial holds it.
+	live atomic.Pointer[amqp091.Connection]
+}
+
+func NewRabbitMQPublisher(url, queue string) *RabbitMQPublisher {
+	return &RabbitMQPublisher{url: url, queue: queue}
+}
+
+func (p *RabbitMQPublisher) Publish(ctx context.Context, job ImageJob) error {
+	return p.PublishBatch(ctx, []ImageJob{job})
+}
+
+// PublishBatch publishes jobs over a single channel, so large imports don't contend for the
+// publisher once per image. Messages carry their publishing time, from which the microservice
+// measures how long they waited in the queue, and the trace context of a producer span, so
+// that their processing joins the trace of the request that queued them.
+func (p *RabbitMQPublisher) PublishBatch(ctx context.Context, jobs []ImageJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "publish "+p.queue,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingDestinationName(p.queue),
+			attribute.Int("messaging.batch.message_count", len(jobs)),
+		))
+	defer span.End()
+
+	err := p.publishBatch(ctx, jobs)
+	if err != nil {
+		metrics.PublishFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (p *RabbitMQPublisher) publishBatch(ctx context.Context, jobs []ImageJob) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	requestID := utils.RequestID(ctx)
+	for _, job := range jobs {
+		if job.RequestID == "" {
+			job.RequestID = requestID
+		}
+		body, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("failed to encode image job: %v", err)
+		}
+		headers := amqp091.Table{}
+		otel.GetTextMapPropagator().Inject(ctx, tracing.AMQPHeaders(headers))
+		err = ch.PublishWithContext(ctx, "", p.queue, false, false, amqp091.Publishing{
+			Headers:       headers,
+			ContentType:   "application/json",
+			CorrelationId: job.RequestID,
+			DeliveryMode:  amqp091.Persistent,
+			Timestamp:     time.Now(),
+			Body:          body,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping checks that RabbitMQ is reachable. A publisher with an open connection is; otherwise
+// Ping dials a connection of its own, bounded by ctx's deadline, rather than waiting for the
+// publisher's lock while another caller dials.
+func (p *RabbitMQPublisher) Ping(ctx context.Context) error {
+	if conn := p.live.Load(); conn != nil && !conn.IsClosed() {
+		return nil
+	}
+
+	timeout := pingTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+	conn, err := amqp091.DialConfig(p.url, amqp091.Config{
+		Heartbeat: 10 * time.Second,
+		Locale:    "en_US",
+		Dial:      amqp091.DefaultDial(timeout),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+	return conn.Close()
+}
+
+// channel returns an open channel, dialing RabbitMQ and declaring the queue if needed.
+// The caller must hold p.mu.
+func (p *RabbitMQPublisher) channel() (*amqp091.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() && !p.conn.IsClosed() {
+		return p.ch, nil
+	}
+	p.closeLocked()
+
+	conn, err := amqp091.Dial(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+
+	if _, err := ch.QueueDeclare(p.queue, true, false, false, false, nil); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare a queue: %v", err)
+	}
+
+	p.conn, p.ch = conn, ch
+	p.live.Store(conn)
+	return ch, nil
+}
+
+// Close releases the underlying connection.
+func (p *RabbitMQPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+	return nil
+}
+
+func (p *RabbitMQPublisher) closeLocked() {
+	if p.ch != nil {
+		p.ch.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch = nil, nil
+	p.live.Store(nil)
+}
@@ -0,0 +1,63 @@
+package queue_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/queue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// silentBroker accepts connections and never answers them, like a broker that hangs.
+func silentBroker(t *testing.T) (url string, accepted <-chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan struct{}, 8)
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			ch <- struct{}{}
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return "amqp://guest:guest@" + ln.Addr().String() + "/", ch
+}
+
+func TestPingDoesNotWaitForPublish(t *testing.T) {
+	url, accepted := silentBroker(t)
+	p := queue.NewRabbitMQPublisher(url, queue.ImageProcessingQueue)
+
+	// The publish holds the publisher until its dial fails, when the test closes the broker
+	go p.Publish(context.Background(), queue.ImageJob{ProductID: 1})
+	<-accepted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Ping(ctx)
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+}
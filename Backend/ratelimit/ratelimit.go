@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as "rate:burst", e.g. "0.5:5".
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected rate:burst", s)
+	}
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate in rate limit %q", s)
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil || b <= 0 {
+		return Limit{}, fmt.Errorf("invalid burst in rate limit %q", s)
+	}
+	return Limit{Rate: r, Burst: b}, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// RetryAfter is how long until a token is available when the request was not allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// Limiter takes tokens from per-key buckets.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// result builds a Result from the tokens left in a bucket after a request.
+func result(allowed bool, tokens float64, limit Limit) Result {
+	res := Result{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Config holds the default limit and per-route overrides.
+type Config struct {
+	Default Limit
+	Routes  map[string]Limit
+}
+
+// For returns the limit that applies to route.
+func (c Config) For(route string) Limit {
+	if limit, ok := c.Routes[route]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// ParseOverrides parses per-route overrides written as "route=rate:burst,route=rate:burst".
+func ParseOverrides(s string) (map[string]Limit, error) {
+	routes := map[string]Limit{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit override %q: expected route=rate:burst", entry)
+		}
+		limit, err := ParseLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.TrimSpace(route)] = limit
+	}
+	return routes, nil
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		res, err := limiter.Allow(ctx, "client", limit)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res, _ := limiter.Allow(ctx, "client", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, res.ResetAfter)
+
+	// Other clients have their own bucket
+	res, _ = limiter.Allow(ctx, "other", limit)
+	assert.True(t, res.Allowed)
+
+	// Half a second refills one token at 2 tokens/second
+	now = now.Add(500 * time.Millisecond)
+	res, _ = limiter.Allow(ctx, "client", limit)
+	assert.True(t, res.Allowed)
+	res, _ = limiter.Allow(ctx, "client", limit)
+	assert.False(t, res.Allowed)
+
+	// Idle buckets that have refilled are swept
+	now = now.Add(2 * sweepInterval)
+	limiter.Allow(ctx, "client", limit)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	limiter := NewRedisLimiter(client)
+	limit := Limit{Rate: 1, Burst: 5}
+
+	mock.ExpectEvalSha(tokenBucketScript.Hash(), []string{"ratelimit:client"}, limit.Rate, limit.Burst).
+		SetVal([]interface{}{int64(1), "3.5"})
+	res, err := limiter.Allow(context.Background(), "client", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Remaining: 3, ResetAfter: 1500 * time.Millisecond}, res)
+
+	mock.ExpectEvalSha(tokenBucketScript.Hash(), []string{"ratelimit:client"}, limit.Rate, limit.Burst).
+		SetVal([]interface{}{int64(0), "0.25"})
+	res, err = limiter.Allow(context.Background(), "client", limit)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 750*time.Millisecond, res.RetryAfter)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseOverrides(t *testing.T) {
+	routes, err := ParseOverrides("products:create=0.5:2, users:create=5:10")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"products:create": {Rate: 0.5, Burst: 2},
+		"users:create":    {Rate: 5, Burst: 10},
+	}, routes)
+
+	cfg := Config{Default: Limit{Rate: 10, Burst: 20}, Routes: routes}
+	assert.Equal(t, Limit{Rate: 0.5, Burst: 2}, cfg.For("products:create"))
+	assert.Equal(t, Limit{Rate: 10, Burst: 20}, cfg.For("products:list"))
+
+	for _, bad := range []string{"products:create", "products:create=1", "products:create=0:1", "products:create=1:x"} {
+		_, err := ParseOverrides(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills and takes a token from the bucket stored in a hash at KEYS[1],
+// using the Redis server clock so every instance sees the same time.
+// ARGV: rate (tokens/second), burst. Returns {allowed, tokens remaining}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter keeps token buckets in Redis so limits are shared by every Backend instance.
+type RedisLimiter struct {
+	client redis.Scripter
+	prefix string
+}
+
+func NewRedisLimiter(client redis.Scripter) *RedisLimiter {
+	return &RedisLimiter{client: client, prefix: "ratelimit:"}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	reply, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key}, limit.Rate, limit.Burst).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to run rate limit script: %v", err)
+	}
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	allowed, _ := reply[0].(int64)
+	remaining, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(remaining, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+	return result(allowed == 1, tokens, limit), nil
+}
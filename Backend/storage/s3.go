@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxDeleteBatch is the most keys S3 accepts in a single DeleteObjects call.
+const maxDeleteBatch = 1000
+
+// ImageStore removes processed images uploaded by the image microservice.
+type ImageStore interface {
+	// DeleteImages removes the objects behind the given URLs. URLs that do not point into
+	// the store are ignored.
+	DeleteImages(ctx context.Context, urls []string) error
+}
+
+// S3ImageStore deletes images from the bucket the microservice uploads to. It understands the
+// https://{bucket}.s3.amazonaws.com/{key} URLs the microservice stores on products.
+type S3ImageStore struct {
+	client *s3.S3
+	bucket string
+}
+
+func NewS3ImageStore(region, accessKey, secretKey, bucket string) (*S3ImageStore, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+	return &S3ImageStore{client: s3.New(sess), bucket: bucket}, nil
+}
+
+func (s *S3ImageStore) DeleteImages(ctx context.Context, urls []string) error {
+	var objects []*s3.ObjectIdentifier
+	for _, u := range urls {
+		if key, ok := ObjectKey(s.bucket, u); ok {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+	}
+
+	for start := 0; start < len(objects); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(objects))
+		out, err := s.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: objects[start:end], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete images from S3: %v", err)
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %d images from S3, first error: %s",
+				len(out.Errors), aws.StringValue(out.Errors[0].Message))
+		}
+	}
+	return nil
+}
+
+// ObjectKey returns the object key for an image URL in bucket, or false if the URL points
+// somewhere else.
+func ObjectKey(bucket, imageURL string) (string, bool) {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Host != bucket+".s3.amazonaws.com" {
+		return "", false
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	return key, key != ""
+}
@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"testing"
+
+	"backend/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectKey(t *testing.T) {
+	key, ok := storage.ObjectKey("bucket", "https://bucket.s3.amazonaws.com/photo.jpg_compressed.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, "photo.jpg_compressed.jpg", key)
+
+	for _, u := range []string{
+		"https://other.s3.amazonaws.com/photo.jpg",
+		"https://example.com/photo.jpg",
+		"https://bucket.s3.amazonaws.com/",
+		"::not a url",
+	} {
+		_, ok := storage.ObjectKey("bucket", u)
+		assert.False(t, ok, u)
+	}
+}
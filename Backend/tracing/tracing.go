@@ -0,0 +1,79 @@
+// Package tracing sets up OpenTelemetry tracing for the Backend and carries trace context
+// through RabbitMQ messages to the image microservice.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Tracer starts the Backend's spans. Until Init installs a provider its spans are not recorded.
+var Tracer = otel.Tracer("backend")
+
+// Init installs the global tracer provider and the W3C trace context propagator. The exporter
+// is chosen by OTEL_TRACES_EXPORTER: "otlp" sends spans over OTLP/HTTP to
+// OTEL_EXPORTER_OTLP_ENDPOINT (http://localhost:4318 by default), "console" prints them to
+// stdout, and "none", the default, records nothing. Sampling follows OTEL_TRACES_SAMPLER. The
+// returned function flushes the spans not exported yet.
+func Init(ctx context.Context, service string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// AMQPHeaders adapts the headers of an AMQP message for injecting and extracting trace
+// context with a propagator.
+type AMQPHeaders amqp091.Table
+
+func (h AMQPHeaders) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h AMQPHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+func (h AMQPHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
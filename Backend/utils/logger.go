@@ -1,5 +1,41 @@
 package utils
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
 
 var Logger = logrus.New()
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// WithLogger returns a copy of ctx carrying logger, e.g. one with the fields of a request.
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Log returns the logger carried by ctx, or one without fields if it carries none. Handlers
+// log through it so that their lines can be tied to the request that produced them.
+func Log(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return logger
+	}
+	return logrus.NewEntry(Logger)
+}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request being handled.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside of requests.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MaxRequestBodyBytes caps the size of JSON request bodies accepted by the API.
+const MaxRequestBodyBytes = 1 << 20
+
+// DecodeJSONBody decodes a single JSON object from the request body into dst. Unknown fields,
+// trailing data and bodies larger than MaxRequestBodyBytes are rejected. On failure it writes
+// the appropriate 400 or 413 response and returns the error.
+func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("request body must contain a single JSON object")
+	}
+	if err == nil {
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = fmt.Errorf("request body must not be larger than %d bytes", maxBytesErr.Limit)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+	Logger.WithError(err).Warn("Failed to decode request body")
+	return err
+}
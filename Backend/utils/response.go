@@ -1,17 +1,34 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 )
 
+// StatusClientClosedRequest is recorded for requests whose client went away before they were
+// answered. Nobody reads the response; the status only shows up in logs and metrics.
+const StatusClientClosedRequest = 499
+
 func SendJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(data)
 }
 
-func HandleError(w http.ResponseWriter, err error, statusCode int) {
-	http.Error(w, err.Error(), statusCode)
-	Logger.Error(err)
+// HandleError answers with err and logs it with the request's logger. Errors caused by the
+// request's context ending, whatever form the driver reports them in, answer 503 if the
+// request timed out and StatusClientClosedRequest if the client went away.
+func HandleError(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+		Log(r.Context()).WithError(err).Warn("Request timed out")
+	case context.Canceled:
+		http.Error(w, "Request canceled", StatusClientClosedRequest)
+		Log(r.Context()).WithError(err).Info("Request canceled by the client")
+	default:
+		http.Error(w, err.Error(), statusCode)
+		Log(r.Context()).Error(err)
+	}
 }
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+)
+
+var validate = newValidator()
+
+// FieldError describes a single invalid field in a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request body fails validation.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterValidation("notblank", validators.NotBlank)
+
+	// Report fields by their JSON name so clients can map errors back to the payload
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// Validate checks v against its `validate` struct tags. It returns a *ValidationError listing
+// the invalid fields, nil if v is valid, or another error if v could not be validated at all,
+// e.g. because it isn't a struct or a pointer to one.
+func Validate(v interface{}) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return fmt.Errorf("failed to validate %T: %v", v, err)
+	}
+
+	result := &ValidationError{}
+	for _, fe := range verrs {
+		result.Fields = append(result.Fields, FieldError{
+			Field:   fieldPath(fe),
+			Message: fieldMessage(fe),
+		})
+	}
+	return result
+}
+
+// fieldPath strips the top-level struct name from the namespace, e.g. "Product.product_images[0]"
+// becomes "product_images[0]".
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "notblank":
+		return "is required"
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", formatParam(fe))
+	case "gte":
+		return fmt.Sprintf("must be at least %s", formatParam(fe))
+	case "lte":
+		return fmt.Sprintf("must be at most %s", formatParam(fe))
+	case "ne":
+		return fmt.Sprintf("must not be %s", formatParam(fe))
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "iso4217":
+		return "must be an ISO 4217 currency code"
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must contain at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "http_url":
+		return "must be a valid http or https URL"
+	default:
+		return fmt.Sprintf("failed %q validation", fe.Tag())
+	}
+}
+
+// formatParam renders a numeric tag parameter using the field's own String method when it has
+// one, so a Price limit of 9999999999 is reported as "99999999.99".
+func formatParam(fe validator.FieldError) string {
+	t := fe.Type()
+	if t == nil || t.Kind() != reflect.Int64 || !t.Implements(reflect.TypeOf((*fmt.Stringer)(nil)).Elem()) {
+		return fe.Param()
+	}
+	n, err := strconv.ParseInt(fe.Param(), 10, 64)
+	if err != nil {
+		return fe.Param()
+	}
+	v := reflect.New(t).Elem()
+	v.SetInt(n)
+	return v.Interface().(fmt.Stringer).String()
+}
+
+// HandleValidationError answers with the error returned by Validate: 422 listing the invalid
+// fields, or 500 if the request could not be validated.
+func HandleValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		SendValidationError(w, r, verr)
+		return
+	}
+	HandleError(w, r, err, http.StatusInternalServerError)
+}
+
+// SendValidationError writes a 422 response listing the invalid fields.
+func SendValidationError(w http.ResponseWriter, r *http.Request, err *ValidationError) {
+	SendJSONResponse(w, map[string]interface{}{
+		"error":  "validation failed",
+		"fields": err.Fields,
+	}, http.StatusUnprocessableEntity)
+	Log(r.Context()).WithField("fields", err.Fields).Warn("Request failed validation")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpAddr is where GET /metrics, /healthz and /readyz are served
+const httpAddr = ":8083"
+
+// healthCheckTimeout bounds each readiness check
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck checks that a dependency is reachable. Failed critical checks make the service
+// unready; the others only report it as degraded.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type healthResult struct {
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// serveHTTP serves metrics and health probes until the process exits.
+func serveHTTP(checks ...healthCheck) {
+	log.Printf("Serving metrics and health probes on %s", httpAddr)
+	if err := http.ListenAndServe(httpAddr, newHTTPHandler(checks...)); err != nil {
+		log.Printf("HTTP server stopped: %v", err)
+	}
+}
+
+// newHTTPHandler routes GET /metrics, /healthz and /readyz.
+func newHTTPHandler(checks ...healthCheck) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, results := runHealthChecks(r.Context(), checks)
+		code := http.StatusOK
+		if status == "unavailable" {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, map[string]interface{}{"status": status, "checks": results})
+	})
+	return mux
+}
+
+// runHealthChecks runs checks concurrently and returns the overall status, "ok", "degraded"
+// or "unavailable", with the result of each check.
+func runHealthChecks(ctx context.Context, checks []healthCheck) (string, map[string]healthResult) {
+	results := make([]healthResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	status := "ok"
+	byName := make(map[string]healthResult, len(checks))
+	for i, c := range checks {
+		byName[c.name] = results[i]
+		switch {
+		case results[i].Status == "ok":
+		case c.critical:
+			status = "unavailable"
+		case status == "ok":
+			status = "degraded"
+		}
+	}
+	return status, byName
+}
+
+// run runs the check, giving up after healthCheckTimeout even if it ignores its context.
+func (c healthCheck) run(ctx context.Context) healthResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := healthResult{Status: "ok", Critical: c.critical, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+	}
+	return result
+}
+
+func writeHealth(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
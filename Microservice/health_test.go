@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func healthy(ctx context.Context) error { return nil }
+
+func unreachable(ctx context.Context) error { return errors.New("connection refused") }
+
+func get(handler http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHealthz(t *testing.T) {
+	w := get(newHTTPHandler(healthCheck{name: "postgres", critical: true, check: unreachable}), "/healthz")
+
+	// Liveness doesn't depend on the dependencies
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []healthCheck
+		code   int
+		status string
+	}{
+		{"Ready", []healthCheck{{name: "postgres", critical: true, check: healthy}, {name: "redis", check: healthy}}, http.StatusOK, "ok"},
+		{"Degraded", []healthCheck{{name: "postgres", critical: true, check: healthy}, {name: "redis", check: unreachable}}, http.StatusOK, "degraded"},
+		{"Unavailable", []healthCheck{{name: "postgres", critical: true, check: unreachable}, {name: "redis", check: healthy}}, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(newHTTPHandler(tt.checks...), "/readyz")
+
+			var body struct {
+				Status string                  `json:"status"`
+				Checks map[string]healthResult `json:"checks"`
+			}
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.status, body.Status)
+			assert.Len(t, body.Checks, 2)
+			for _, c := range tt.checks {
+				assert.Equal(t, c.critical, body.Checks[c.name].Critical)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	jobsFailed.WithLabelValues("download")
+	stageDuration.WithLabelValues("download").Observe(0.1)
+
+	w := get(newHTTPHandler(), "/metrics")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, name := range []string{
+		"image_jobs_processed_total",
+		"image_jobs_failed_total",
+		"image_stage_duration_seconds",
+		"image_queue_lag_seconds",
+		"image_compression_bytes_saved_total",
+	} {
+		assert.Contains(t, w.Body.String(), name)
+	}
+}
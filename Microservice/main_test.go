@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spans records the spans of every test. The tracer delegates to the first provider set.
+var spans = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+// serveImage serves body at /lamp.jpg for the duration of the test.
+func serveImage(t *testing.T, body []byte) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/lamp.jpg"
+}
+
+func jpegImage(t *testing.T) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil))
+	return buf.Bytes()
+}
+
+// captureLogs returns the buffer the standard logger writes to until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+// imageDelivery returns a job for productID queued by request req-42 under a trace.
+func imageDelivery(imageURL string, productID int) amqp091.Delivery {
+	return amqp091.Delivery{
+		Headers:       amqp091.Table{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		CorrelationId: "req-42",
+		Timestamp:     time.Now(),
+		Body:          []byte(fmt.Sprintf(`{"product_id": %d, "image_url": %q}`, productID, imageURL)),
+	}
+}
+
+// consumerSpan returns the last ended span of a job.
+func consumerSpan(t *testing.T) sdktrace.ReadOnlySpan {
+	var found sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == "process "+queueName {
+			found = span
+		}
+	}
+	if !assert.NotNil(t, found) {
+		t.FailNow()
+	}
+	return found
+}
+
+func TestHandleMessage(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	db = sqlDB
+
+	var redisExpect redismock.ClientMock
+	rdb, redisExpect = redismock.NewClientMock()
+
+	uploadImage = func(bucket, key string, file io.ReadSeeker) (string, error) {
+		assert.Equal(t, "lamp.jpg_compressed.jpg", key)
+		return "https://bucket.s3.amazonaws.com/" + key, nil
+	}
+	t.Cleanup(func() { uploadImage = uploadToS3 })
+
+	logs := captureLogs(t)
+	imageURL := serveImage(t, jpegImage(t))
+	s3URL := "https://bucket.s3.amazonaws.com/lamp.jpg_compressed.jpg"
+	processed := testutil.ToFloat64(jobsProcessed)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE products\s+SET compressed_product_images = array_append\(compressed_product_images, \$1\), version = version \+ 1`).
+		WithArgs(s3URL, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(`UPDATE product_variants`).
+		WithArgs(s3URL, 7, imageURL, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The Backend's copy of the product and the lists it may be in are dropped
+	redisExpect.ExpectDel("product:7").SetVal(1)
+	redisExpect.ExpectPublish(productInvalidationChannel, "product:7").SetVal(1)
+	redisExpect.ExpectIncr("tag:products:all").SetVal(1)
+	redisExpect.ExpectIncr("tag:products:user:3").SetVal(1)
+
+	handleMessage(queueName, imageDelivery(imageURL, 7))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisExpect.ExpectationsWereMet())
+	assert.Equal(t, processed+1, testutil.ToFloat64(jobsProcessed))
+
+	// Every log line carries the ID of the request that queued the job
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	assert.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.Contains(t, line, "request_id=req-42 ")
+	}
+
+	// The job continues the trace of the request, with a span per stage
+	span := consumerSpan(t)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Contains(t, span.Attributes(), attribute.String("http.request.id", "req-42"))
+	assert.Contains(t, span.Attributes(), attribute.Int("product.id", 7))
+	var stages []string
+	for _, s := range spans.Ended() {
+		if s.Parent().SpanID() == span.SpanContext().SpanID() {
+			stages = append(stages, s.Name())
+		}
+	}
+	assert.Equal(t, []string{"download", "compress", "upload", "db"}, stages)
+}
+
+func TestHandleMessageDeletedProduct(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	db = sqlDB
+
+	var redisExpect redismock.ClientMock
+	rdb, redisExpect = redismock.NewClientMock()
+
+	uploadImage = func(bucket, key string, file io.ReadSeeker) (string, error) {
+		return "https://bucket.s3.amazonaws.com/" + key, nil
+	}
+	t.Cleanup(func() { uploadImage = uploadToS3 })
+
+	logs := captureLogs(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE products`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	handleMessage(queueName, imageDelivery(serveImage(t, jpegImage(t)), 8))
+
+	// Nothing changed, so nothing cached is dropped
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisExpect.ExpectationsWereMet())
+	assert.Contains(t, logs.String(), "request_id=req-42 Product ID 8 no longer exists")
+}
+
+func TestHandleMessageFailedStage(t *testing.T) {
+	logs := captureLogs(t)
+	failed := testutil.ToFloat64(jobsFailed.WithLabelValues("compress"))
+
+	handleMessage(queueName, imageDelivery(serveImage(t, []byte("not an image")), 9))
+
+	assert.Equal(t, failed+1, testutil.ToFloat64(jobsFailed.WithLabelValues("compress")))
+	assert.Contains(t, logs.String(), "request_id=req-42 Error processing image")
+	assert.Equal(t, codes.Error, consumerSpan(t).Status().Code)
+}
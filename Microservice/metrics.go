@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	jobsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_jobs_processed_total",
+		Help: "Image jobs processed successfully.",
+	})
+	jobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_jobs_failed_total",
+		Help: "Image jobs that failed, by the stage they failed in.",
+	}, []string{"stage"})
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "image_stage_duration_seconds",
+		Help:    "Time taken by each stage of processing an image: download, compress, upload or db.",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"stage"})
+	// AMQP timestamps have a resolution of one second
+	queueLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "image_queue_lag_seconds",
+		Help:    "Time image jobs waited in the queue between being published and consumed.",
+		Buckets: []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+	bytesSaved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_compression_bytes_saved_total",
+		Help: "Bytes saved by compressing images; images that grew are not counted.",
+	})
+)
+
+// startStage starts a stage of processing an image in a child span of ctx. The returned
+// function ends it with the stage's error, observing its duration if it succeeded and counting
+// the job as failed in it otherwise.
+func startStage(ctx context.Context, stage string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, stage)
+	return ctx, func(err error) {
+		if err != nil {
+			jobsFailed.WithLabelValues(stage).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+		}
+		span.End()
+	}
+}